@@ -0,0 +1,72 @@
+// Command example serves a small API behind the CORS middleware, configured
+// for a single-page application on another origin that logs in with cookies.
+//
+//	go run ./cmd/example -addr :8080 -origin http://localhost:3000
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/quintinheard/traefik-cors/cors"
+)
+
+const sessionCookie = "session"
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	origin := flag.String("origin", "http://localhost:3000", "origin of the single-page application")
+	flag.Parse()
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{*origin}
+	o.AllowCredentials = true
+	o.AllowMethods = []string{http.MethodGet, http.MethodPost}
+	o.AllowHeaders = []string{"Content-Type"}
+	o.ExposeHeaders = []string{"X-Session-Expires"}
+
+	if err := o.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", login)
+	mux.HandleFunc("/me", me)
+
+	log.Printf("listening on %s for %s", *addr, *origin)
+	log.Fatal(http.ListenAndServe(*addr, o.NewMiddleware(mux)))
+}
+
+// login starts a session. Cross-site cookies must be SameSite=None and
+// Secure, which CORS cannot change; browsers only relax Secure on localhost.
+func login(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "example",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	rw.Header().Set("X-Session-Expires", "3600")
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// me returns the session's user, which requires the cookie set by login.
+func me(rw http.ResponseWriter, req *http.Request) {
+	if _, err := req.Cookie(sessionCookie); err != nil {
+		rw.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write([]byte(`{"user":"example"}`))
+}
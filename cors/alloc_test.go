@@ -0,0 +1,97 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// discardWriter is a http.ResponseWriter reusing a single header map, so
+// allocation measurements only count the handler's own work.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func allocHandler() http.Handler {
+	o := NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+	o.AllowMethods = []string{http.MethodGet, http.MethodPost}
+	o.AllowHeaders = []string{"Content-Type"}
+	o.ExposeHeaders = []string{"Location"}
+	o.AllowCredentials = true
+
+	return o.NewHandler()
+}
+
+func hotPathRequests() map[string]*http.Request {
+	noOrigin := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+
+	actual := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	actual.Header.Set(HeaderOrigin, "https://example.com")
+
+	preflight := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	preflight.Header.Set(HeaderOrigin, "https://example.com")
+	preflight.Header.Set(HeaderRequestMethod, http.MethodPost)
+	preflight.Header.Set(HeaderRequestHeaders, "content-type")
+
+	return map[string]*http.Request{
+		"no origin": noOrigin,
+		"actual":    actual,
+		"preflight": preflight,
+	}
+}
+
+func TestHandler_AllocBudgets(t *testing.T) {
+	budgets := map[string]float64{
+		"no origin": allocBudgetNoOrigin,
+		"actual":    allocBudgetActual,
+		"preflight": allocBudgetPreflight,
+	}
+
+	h := allocHandler()
+
+	for name, req := range hotPathRequests() {
+		rw := &discardWriter{header: make(http.Header)}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			h.ServeHTTP(rw, req)
+
+			for k := range rw.header {
+				delete(rw.header, k)
+			}
+		})
+
+		t.Logf("%s path: %.0f allocations, budget %.0f", name, allocs, budgets[name])
+		require.LessOrEqual(t, allocs, budgets[name], fmt.Sprintf("%s path allocates more than its budget", name))
+	}
+}
+
+func TestHandler_TimeBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping timing check in short mode")
+	}
+
+	h := allocHandler()
+
+	for name, req := range hotPathRequests() {
+		req := req
+		rw := &discardWriter{header: make(http.Header)}
+
+		res := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(rw, req)
+			}
+		})
+
+		perOp := time.Duration(res.NsPerOp())
+		require.Less(t, int64(perOp), int64(timeBudget), fmt.Sprintf("%s path took %s per request", name, perOp))
+	}
+}
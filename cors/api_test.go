@@ -0,0 +1,229 @@
+package cors_test
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateAPI = flag.Bool("update", false, "regenerate testdata/api.txt")
+
+const apiBaseline = "testdata/api.txt"
+
+// TestAPI guards the exported API of the package against accidental changes.
+// Run `go test -run TestAPI -update` and commit testdata/api.txt along with
+// any deliberate change.
+func TestAPI(t *testing.T) {
+	api := exportedAPI(t, ".")
+
+	if *updateAPI {
+		require.Nil(t, ioutil.WriteFile(apiBaseline, []byte(strings.Join(api, "\n")+"\n"), 0o600))
+
+		return
+	}
+
+	b, err := ioutil.ReadFile(apiBaseline)
+	require.Nil(t, err)
+
+	baseline := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+
+	removed, added := diffAPI(baseline, api)
+	require.Empty(t, removed, "exported API changed incompatibly; restore it, or run with -update if intended")
+	require.Empty(t, added, "exported API grew; run with -update to record it")
+}
+
+// diffAPI returns the lines of baseline missing from api, and the reverse.
+func diffAPI(baseline, api []string) (removed, added []string) {
+	seen := make(map[string]bool, len(api))
+	for _, l := range api {
+		seen[l] = true
+	}
+
+	for _, l := range baseline {
+		if !seen[l] {
+			removed = append(removed, l)
+		}
+
+		delete(seen, l)
+	}
+
+	for _, l := range api {
+		if seen[l] {
+			added = append(added, l)
+		}
+	}
+
+	return removed, added
+}
+
+// exportedAPI returns one sorted line per exported declaration of the
+// non-test Go files in dir.
+func exportedAPI(t *testing.T, dir string) []string {
+	t.Helper()
+
+	fset := token.NewFileSet()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	require.Nil(t, err)
+
+	var api []string
+
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		require.Nil(t, err)
+
+		for _, decl := range f.Decls {
+			api = append(api, declAPI(fset, decl)...)
+		}
+	}
+
+	sort.Strings(api)
+
+	return api
+}
+
+func declAPI(fset *token.FileSet, decl ast.Decl) []string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if !d.Name.IsExported() {
+			return nil
+		}
+
+		if d.Recv == nil {
+			return []string{"func " + d.Name.Name + signature(fset, d.Type)}
+		}
+
+		recv := typeString(fset, d.Recv.List[0].Type)
+		if !ast.IsExported(strings.TrimPrefix(recv, "*")) {
+			return nil
+		}
+
+		return []string{"method (" + recv + ") " + d.Name.Name + signature(fset, d.Type)}
+	case *ast.GenDecl:
+		var api []string
+
+		for _, spec := range d.Specs {
+			api = append(api, specAPI(fset, d.Tok, spec)...)
+		}
+
+		return api
+	}
+
+	return nil
+}
+
+func specAPI(fset *token.FileSet, tok token.Token, spec ast.Spec) []string {
+	var api []string
+
+	switch s := spec.(type) {
+	case *ast.ValueSpec:
+		for _, n := range s.Names {
+			if !n.IsExported() {
+				continue
+			}
+
+			line := tok.String() + " " + n.Name
+			if s.Type != nil {
+				line += " " + typeString(fset, s.Type)
+			}
+
+			api = append(api, line)
+		}
+	case *ast.TypeSpec:
+		if !s.Name.IsExported() {
+			return nil
+		}
+
+		api = append(api, typeAPI(fset, s)...)
+	}
+
+	return api
+}
+
+func typeAPI(fset *token.FileSet, s *ast.TypeSpec) []string {
+	name := s.Name.Name
+
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		api := []string{"type " + name + " struct"}
+
+		for _, f := range t.Fields.List {
+			for _, n := range f.Names {
+				if n.IsExported() {
+					api = append(api, "field "+name+"."+n.Name+" "+typeString(fset, f.Type))
+				}
+			}
+		}
+
+		return api
+	case *ast.InterfaceType:
+		api := []string{"type " + name + " interface"}
+
+		for _, m := range t.Methods.List {
+			if ft, ok := m.Type.(*ast.FuncType); ok && len(m.Names) > 0 {
+				api = append(api, "method ("+name+") "+m.Names[0].Name+signature(fset, ft))
+			}
+		}
+
+		return api
+	}
+
+	return []string{"type " + name + " " + typeString(fset, s.Type)}
+}
+
+// signature renders a function type without parameter names, which may change
+// without breaking callers.
+func signature(fset *token.FileSet, ft *ast.FuncType) string {
+	sig := "(" + fieldTypes(fset, ft.Params) + ")"
+
+	switch {
+	case ft.Results == nil:
+	case len(ft.Results.List) == 1 && len(ft.Results.List[0].Names) == 0:
+		sig += " " + fieldTypes(fset, ft.Results)
+	default:
+		sig += " (" + fieldTypes(fset, ft.Results) + ")"
+	}
+
+	return sig
+}
+
+func fieldTypes(fset *token.FileSet, fields *ast.FieldList) string {
+	var types []string
+
+	for _, f := range fields.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+
+		for i := 0; i < n; i++ {
+			types = append(types, typeString(fset, f.Type))
+		}
+	}
+
+	return strings.Join(types, ", ")
+}
+
+func typeString(fset *token.FileSet, expr ast.Expr) string {
+	var buf bytes.Buffer
+
+	if err := printer.Fprint(&buf, fset, expr); err != nil {
+		panic(err)
+	}
+
+	return buf.String()
+}
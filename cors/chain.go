@@ -0,0 +1,175 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ChainLink is an element of a Chain: Options applied to the requests that
+// match its predicate.
+type ChainLink struct {
+	// Origins restricts the link to requests whose Origin matches one of these
+	// patterns, using the AllowOrigins pattern syntax. Empty means any request,
+	// while entries that do not compile match no origin, so a link whose
+	// entries are all invalid never matches.
+	Origins []string
+	// Match restricts the link to requests for which it returns true. For
+	// preflight requests, as told by the link's Options, it is called with the
+	// method of the request being preflighted, so a preflight selects the same
+	// link as the request that follows it. Nil means any request.
+	Match func(*http.Request) bool
+	// Options are applied to the requests selected by this link.
+	Options *Options
+
+	patterns []Pattern
+	handler  *handler
+}
+
+// Chain is an ordered list of policies with first-match semantics: a request
+// is processed with the Options of the first link whose predicate matches it,
+// and only those. Requests matching no link are not processed, except for
+// preflight requests, which are denied.
+type Chain []ChainLink
+
+// Validate reports the first configuration error of the chain, either in the
+// Origins or in the Options of a link.
+func (c Chain) Validate() error {
+	for i, l := range c {
+		if l.Options == nil {
+			return fmt.Errorf("chain link %d: missing options", i)
+		}
+
+		for _, origin := range l.Origins {
+			if _, err := l.Options.compile(origin); err != nil {
+				return fmt.Errorf("chain link %d: %w", i, err)
+			}
+		}
+
+		if err := l.Options.Validate(); err != nil {
+			return fmt.Errorf("chain link %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// NewHandler returns a http.Handler processing CORS requests with the first
+// matching link of the chain, like Options.NewHandler does. The chain must not
+// be modified afterwards.
+func (c Chain) NewHandler() http.Handler {
+	for i := range c {
+		l := &c[i]
+		l.patterns = l.patterns[:0]
+
+		for _, origin := range l.Origins {
+			if p, err := l.Options.compile(origin); err == nil {
+				l.patterns = append(l.patterns, p)
+			}
+		}
+
+		l.handler = l.Options.NewHandler().(*handler)
+	}
+
+	return chainHandler(c)
+}
+
+// NewMiddleware returns a http.Handler processing CORS requests with the first
+// matching link of the chain, like Options.NewMiddleware does, then passing
+// them on to next.
+func (c Chain) NewMiddleware(next http.Handler) http.Handler {
+	c.NewHandler()
+
+	return &chainMiddleware{chain: c, next: next}
+}
+
+type chainHandler Chain
+
+// ServeHTTP implements http.Handler for the chain.
+func (h chainHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	l := Chain(h).selected(req)
+
+	switch {
+	case l != nil:
+		l.handler.serve(rw, req)
+	case (*Request)(req).IsPreflight():
+		denyUnmatched(rw)
+	}
+}
+
+type chainMiddleware struct {
+	chain Chain
+	next  http.Handler
+}
+
+// ServeHTTP implements http.Handler for the chain middleware.
+func (m *chainMiddleware) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	l := m.chain.selected(req)
+	if l == nil && (*Request)(req).IsPreflight() {
+		denyUnmatched(rw)
+
+		return
+	}
+
+	if l == nil {
+		m.next.ServeHTTP(rw, req)
+
+		return
+	}
+
+	mw := middleware{handler: l.handler, next: m.next}
+	mw.ServeHTTP(rw, req)
+}
+
+// denyUnmatched answers a preflight request matching no link with no CORS
+// headers, so the browser fails it instead of the backend answering it.
+func denyUnmatched(rw http.ResponseWriter) {
+	rw.Header().Add(HeaderVary, HeaderOrigin+", "+HeaderRequestMethod)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// selected returns the first link matching req, or nil.
+func (c Chain) selected(req *http.Request) *ChainLink {
+	r := (*Request)(req)
+
+	var preflight *http.Request
+
+	for i := range c {
+		l := &c[i]
+
+		if len(l.Origins) > 0 && !l.matchesOrigin(r.origin()) {
+			continue
+		}
+
+		if l.Match == nil {
+			return l
+		}
+
+		view := req
+
+		if method := r.RequestedMethod(); method != "" && (*Options)(l.handler).isPreflight(r) {
+			if preflight == nil {
+				preflight = req.Clone(req.Context())
+				preflight.Method = method
+			}
+
+			view = preflight
+		}
+
+		if l.Match(view) {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// matchesOrigin reports whether origin matches one of the link's patterns.
+func (l *ChainLink) matchesOrigin(origin string) bool {
+	for _, p := range l.patterns {
+		if p.Match(origin) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+func chainPolicy(methods []string, origins ...string) *cors.Options {
+	o := cors.NewOptions()
+	o.AllowOrigins = origins
+	o.AllowMethods = methods
+
+	return o
+}
+
+func TestChain_FirstMatch(t *testing.T) {
+	admin := func(req *http.Request) bool { return strings.HasPrefix(req.URL.Path, "/admin") }
+
+	chain := cors.Chain{
+		{Match: admin, Origins: []string{"https://admin.example.com"}, Options: chainPolicy([]string{http.MethodGet}, "https://admin.example.com")},
+		{Match: admin, Options: chainPolicy(nil)},
+		{Options: chainPolicy([]string{http.MethodGet, http.MethodPost}, "https://admin.example.com", "https://app.example.com")},
+	}
+	require.Nil(t, chain.Validate())
+
+	h := chain.NewHandler()
+
+	tests := []struct {
+		path     string
+		origin   string
+		expected string
+		methods  string
+	}{
+		{"/admin/users", "https://admin.example.com", "https://admin.example.com", "GET"},
+		{"/admin/users", "https://app.example.com", "", ""},
+		{"/api/users", "https://app.example.com", "https://app.example.com", "GET, POST"},
+		{"/api/users", "https://admin.example.com", "https://admin.example.com", "GET, POST"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com"+tt.path, nil)
+		req.Header.Set(cors.HeaderOrigin, tt.origin)
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Equal(t, tt.expected, rec.Header().Get(cors.HeaderAllowOrigin), "%s from %s", tt.path, tt.origin)
+		require.Equal(t, tt.methods, rec.Header().Get(cors.HeaderAllowMethods), "%s from %s", tt.path, tt.origin)
+	}
+}
+
+func TestChain_PreflightSelectsSameLink(t *testing.T) {
+	writes := func(req *http.Request) bool { return req.Method != http.MethodGet }
+
+	chain := cors.Chain{
+		{Match: writes, Options: chainPolicy([]string{http.MethodDelete}, "https://admin.example.com")},
+		{Options: chainPolicy([]string{http.MethodGet}, "https://app.example.com")},
+	}
+
+	var forwarded int
+
+	mw := chain.NewMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ }))
+
+	preflight := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	preflight.Header.Set(cors.HeaderOrigin, "https://admin.example.com")
+	preflight.Header.Set(cors.HeaderRequestMethod, http.MethodDelete)
+	preflight.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+	rec := httptest.NewRecorder()
+	mw.ServeHTTP(rec, preflight)
+
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, http.MethodDelete, rec.Header().Get(cors.HeaderAllowMethods))
+	require.Equal(t, http.MethodOptions, preflight.Method)
+
+	actual := httptest.NewRequest(http.MethodDelete, "https://cors.example.com/api/", nil)
+	actual.Header.Set(cors.HeaderOrigin, "https://admin.example.com")
+
+	rec = httptest.NewRecorder()
+	mw.ServeHTTP(rec, actual)
+
+	require.Equal(t, "https://admin.example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, 1, forwarded)
+}
+
+func TestChain_NoMatchDeniesPreflight(t *testing.T) {
+	chain := cors.Chain{
+		{Origins: []string{"https://*.example.com"}, Options: chainPolicy(nil, "*")},
+	}
+
+	var forwarded int
+
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ })
+
+	for _, h := range []http.Handler{chain.NewHandler(), chain.NewMiddleware(next)} {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://evil.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+		require.Equal(t, "Origin, Access-Control-Request-Method", rec.Header().Get(cors.HeaderVary))
+	}
+
+	require.Equal(t, 0, forwarded)
+
+	// other requests matching no link are passed on unprocessed
+	mw := chain.NewMiddleware(next)
+
+	for _, method := range []string{http.MethodOptions, http.MethodGet} {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://evil.com")
+
+		rec := httptest.NewRecorder()
+		mw.ServeHTTP(rec, req)
+
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin), method)
+	}
+
+	require.Equal(t, 2, forwarded)
+}
+
+func TestChain_LenientPreflight(t *testing.T) {
+	lenient := chainPolicy([]string{http.MethodPut}, "https://app.example.com")
+	lenient.LenientPreflight = true
+
+	chain := cors.Chain{
+		{Match: func(req *http.Request) bool { return req.Method != http.MethodGet }, Options: lenient},
+	}
+
+	var forwarded int
+
+	mw := chain.NewMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ }))
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://app.example.com")
+
+	rec := httptest.NewRecorder()
+	mw.ServeHTTP(rec, req)
+
+	require.Equal(t, 0, forwarded)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, http.MethodPut, rec.Header().Get(cors.HeaderAllowMethods))
+
+	// a GET preflight selects no link and is denied
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+	rec = httptest.NewRecorder()
+	mw.ServeHTTP(rec, req)
+
+	require.Equal(t, 0, forwarded)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+}
+
+func TestChain_InvalidOriginsMatchNothing(t *testing.T) {
+	admin := chainPolicy(nil, "*")
+	admin.AllowCredentials = true
+
+	chain := cors.Chain{
+		{Origins: []string{"https//admin.example.com"}, Options: admin},
+		{Options: chainPolicy(nil, "https://app.example.com")},
+	}
+	require.NotNil(t, chain.Validate())
+
+	h := chain.NewHandler()
+
+	for _, origin := range []string{"https://evil.example", "https://admin.example.com"} {
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin), origin)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowCredentials), origin)
+	}
+}
+
+func TestChain_Validate(t *testing.T) {
+	require.NotNil(t, cors.Chain{{}}.Validate())
+	require.NotNil(t, cors.Chain{{Origins: []string{"https//example.com"}, Options: cors.NewOptions()}}.Validate())
+	require.NotNil(t, cors.Chain{{Options: chainPolicy(nil, "https//example.com")}}.Validate())
+}
@@ -0,0 +1,154 @@
+package cors_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requirement is a Fetch Standard requirement implemented by the package,
+// identified by the anchor of its section, with the tests covering it.
+type requirement struct {
+	anchor string
+	text   string
+	tests  []string
+}
+
+// conformance maps the requirements of the CORS protocol to the tests that
+// cover them. A requirement without tests is reported by TestConformance;
+// run it with -v to list them.
+// See: https://fetch.spec.whatwg.org/#http-cors-protocol
+var conformance = []requirement{
+	// Origin header
+	{"#origin-header", "a request without an Origin, or an empty one, is not a CORS request",
+		[]string{"TestRequest_EmptyOriginIsAbsent", "TestOptions_Decide"}},
+	{"#origin-header", "the opaque origin null cannot be allowed by configuration",
+		[]string{"TestCompile_Invalid"}},
+	{"#origin-header", "a malformed Origin never matches an allowed origin",
+		[]string{"TestParseOrigin_Invalid", "TestHandler_ServeHTTP_RefusesUnsafeOrigin"}},
+	{"#ascii-serialisation-of-an-origin", "origins compare in serialized form, ignoring scheme and host case and default ports",
+		[]string{"TestParseOrigin", "TestOptions_GetAllowOrigin_Normalized"}},
+	{"#concept-request-origin", "a same-origin request does not need CORS headers",
+		[]string{"TestHandler_ServeHTTP_SuppressSameOriginHeaders", "TestOptions_GetAllowOrigin_Self"}},
+
+	// Preflight requests
+	{"#cors-preflight-request", "a preflight is an OPTIONS request with Origin and Access-Control-Request-Method",
+		[]string{"TestRequest_IsPreflight", "TestRequest_IsNotPreflight"}},
+	{"#cors-preflight-request", "Access-Control-Request-Headers is optional on a preflight",
+		[]string{"TestRequest_IsPreflight_WithoutRequestHeaders"}},
+	{"#cors-preflight-fetch", "a successful preflight response has an ok status",
+		[]string{"TestHandler_ServeHTTP", "TestHandler_ServeHTTP_DefaultPreflightResponder"}},
+	{"#cors-preflight-fetch", "a preflight is answered without reaching the resource",
+		[]string{"TestMiddleware_ServeHTTP", "TestChain_PreflightSelectsSameLink", "TestLifecycle_SPALogin"}},
+	{"#cors-preflight-fetch", "the requested method must be allowed by Access-Control-Allow-Methods",
+		[]string{"TestHandler_ServeHTTP_EnforceMethods"}},
+	{"#cors-preflight-fetch", "the requested headers must be allowed by Access-Control-Allow-Headers",
+		[]string{"TestHandler_ServeHTTP_EnforceHeaders"}},
+	{"#cors-safelisted-method", "CORS-safelisted methods need not be listed in Access-Control-Allow-Methods",
+		[]string{"TestHandler_ServeHTTP_EnforceMethods"}},
+	{"#cors-safelisted-request-header", "CORS-safelisted request headers need not be listed in Access-Control-Allow-Headers",
+		[]string{"TestHandler_ServeHTTP_EnforceHeaders"}},
+	{"#cors-non-wildcard-request-header-name", "Authorization is not covered by the wildcard Access-Control-Allow-Headers",
+		[]string{"TestHandler_ServeHTTP_EnforceHeaders", "TestHandler_ServeHTTP_IncludeAuthorizationWithWildcard"}},
+	{"#forbidden-header-name", "forbidden request headers are never sent by browsers and need not be allowed",
+		nil},
+
+	// Response headers
+	{"#http-responses", "Access-Control-Allow-Origin is returned on allowed CORS requests",
+		[]string{"TestHandler_ServeHTTP", "TestMiddleware_ServeHTTP", "TestOptions_Decide"}},
+	{"#http-responses", "Access-Control-Allow-Origin echoes the exact request Origin for non-wildcard matches",
+		[]string{"TestOptions_GetAllowOrigin_Patterns", "TestOptions_GetAllowOrigin_AllowLocalhost"}},
+	{"#http-responses", "Access-Control-Allow-Origin is absent for origins that are not allowed",
+		[]string{"TestOptions_Decide", "TestChain_FirstMatch", "TestHandler_ServeHTTP_RequireSecureOrigins"}},
+	{"#http-responses", "Access-Control-Allow-Methods is returned on preflight responses",
+		[]string{"TestHandler_ServeHTTP", "TestChain_FirstMatch"}},
+	{"#http-responses", "Access-Control-Allow-Headers is returned on preflight responses",
+		[]string{"TestHandler_ServeHTTP"}},
+	{"#http-responses", "Access-Control-Expose-Headers is returned on responses to actual requests",
+		[]string{"TestLifecycle_SPALogin", "TestHandler_ServeHTTP_RequireSecureOrigins"}},
+	{"#http-responses", "Access-Control-Allow-Credentials is only ever the value true",
+		[]string{"TestLifecycle_SPALogin"}},
+	{"#http-access-control-max-age", "Access-Control-Max-Age is returned on preflight responses",
+		[]string{"TestHandler_ServeHTTP_RequireSecureOrigins"}},
+	{"#http-access-control-max-age", "a negative Max-Age is not sent and zero disables preflight caching",
+		[]string{"TestOptions_GetMaxAge"}},
+	{"#http-new-header-syntax", "the wildcard Access-Control-Allow-Origin allows any origin",
+		[]string{"TestOptions_AllowOrigin_WildcardWithoutOrigin"}},
+	{"#http-new-header-syntax", "list headers hold comma-separated values",
+		[]string{"TestHandler_ServeHTTP_HeaderListSeparator"}},
+	{"#cors-check", "Access-Control-Allow-Origin holds a single origin, never a list",
+		[]string{"TestHandler_ServeHTTP_RefusesUnsafeOrigin"}},
+
+	// Credentials
+	{"#cors-protocol-and-credentials", "Access-Control-Allow-Credentials is true on credentialed responses",
+		[]string{"TestLifecycle_SPALogin"}},
+	{"#cors-protocol-and-credentials", "the wildcard origin cannot be used with credentials, the Origin is echoed instead",
+		[]string{"TestOptions_GetAllowOrigin_WildcardWithCredentials"}},
+	{"#cors-protocol-and-credentials", "credentials are not allowed on responses denying the origin",
+		[]string{"TestHandler_ServeHTTP_DeniedOrigin"}},
+
+	// Caching
+	{"#cors-protocol-and-http-caches", "Vary includes Origin when Access-Control-Allow-Origin depends on the Origin",
+		[]string{"TestOptions_GetAllowOrigin_AllowLocalhost", "TestOptions_GetAllowOrigin_AllowDomains", "TestLifecycle_SPALogin", "TestOptions_GetVary"}},
+	{"#cors-protocol-and-http-caches", "Vary includes Origin on responses denying an origin that another origin would be allowed",
+		[]string{"TestHandler_ServeHTTP_RequireSecureOriginsWithCredentials", "TestHandler_ServeHTTP_VarySingleOrigin"}},
+	{"#cors-protocol-and-http-caches", "Vary includes Origin on preflight responses as well as actual responses",
+		[]string{"TestLifecycle_SPALogin", "TestHandler_ServeHTTP_VarySingleOrigin"}},
+	{"#cors-protocol-and-http-caches", "a request without an Origin gets no CORS headers but still varies on Origin",
+		[]string{"TestHandler_ServeHTTP_VarySingleOrigin"}},
+}
+
+// TestConformance fails when a test mapped to a requirement no longer exists,
+// and logs the requirements that are not covered by any test.
+func TestConformance(t *testing.T) {
+	defined := testFunctions(t, ".")
+
+	var unmapped []string
+
+	for _, r := range conformance {
+		require.True(t, strings.HasPrefix(r.anchor, "#"), r.text)
+
+		if len(r.tests) == 0 {
+			unmapped = append(unmapped, r.anchor+": "+r.text)
+		}
+
+		for _, name := range r.tests {
+			require.True(t, defined[name], "%s (%s) is mapped to %s, which does not exist", r.anchor, r.text, name)
+		}
+	}
+
+	t.Logf("%d of %d requirements are not covered by a test:", len(unmapped), len(conformance))
+
+	for _, u := range unmapped {
+		t.Log("  " + u)
+	}
+}
+
+// testFunctions returns the names of the test functions defined in dir.
+func testFunctions(t *testing.T, dir string) map[string]bool {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	require.Nil(t, err)
+
+	fset := token.NewFileSet()
+	names := make(map[string]bool)
+
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		require.Nil(t, err)
+
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Test") {
+				names[fn.Name.Name] = true
+			}
+		}
+	}
+
+	return names
+}
@@ -3,9 +3,16 @@
 package cors
 
 import (
+	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
 )
 
 const (
@@ -44,31 +51,185 @@ const (
 	// HeaderRequestMethod indicates which method a future CORS request to the same resource might use.
 	// See: Fetch Standard § 3.2.2. HTTP requests.
 	HeaderRequestMethod = "Access-Control-Request-Method"
+	// HeaderAllow lists the methods supported by the target resource.
+	// See: RFC7231 § 7.4.1. Allow.
+	HeaderAllow = "Allow"
+	// HeaderForwardedProto indicates the scheme a client used to reach a proxy.
+	// It is a de facto standard set by reverse proxies such as Traefik.
+	HeaderForwardedProto = "X-Forwarded-Proto"
+	// HeaderForwardedHost indicates the Host a client sent to a proxy.
+	// It is a de facto standard set by reverse proxies such as Traefik.
+	HeaderForwardedHost = "X-Forwarded-Host"
+	// HeaderForwarded discloses information lost when a proxy is involved,
+	// such as the scheme and Host the client used.
+	// See: RFC7239 § 4. Forwarded HTTP Header Field.
+	HeaderForwarded = "Forwarded"
 	// HeaderRequestHeaders indicates which headers a future CORS request to the same resource might use.
 	// See: Fetch Standard § 3.2.2. HTTP requests.
 	HeaderRequestHeaders = "Access-Control-Request-Headers"
+	// HeaderTimingAllowOrigin lists the origins allowed to read the timing details of a resource, or `*`.
+	// See: Resource Timing § Timing-Allow-Origin Response Header.
+	HeaderTimingAllowOrigin = "Timing-Allow-Origin"
+	// HeaderResourcePolicy indicates which origins may embed a resource fetched in no-cors mode.
+	// See: Fetch Standard § 3.7. `Cross-Origin-Resource-Policy` header.
+	HeaderResourcePolicy = "Cross-Origin-Resource-Policy"
+
+	// ResourcePolicySameOrigin, ResourcePolicySameSite and
+	// ResourcePolicyCrossOrigin are the values of ResourcePolicy.
+	ResourcePolicySameOrigin  = "same-origin"
+	ResourcePolicySameSite    = "same-site"
+	ResourcePolicyCrossOrigin = "cross-origin"
+
+	// HeaderPhaseRequest and HeaderPhaseResponse are the values of
+	// HeaderPhase.
+	HeaderPhaseRequest  = "request"
+	HeaderPhaseResponse = "response"
+
+	// OriginSelf is the AllowOrigins keyword allowing the request's own origin,
+	// that is an Origin whose host and port equal the request's Host.
+	OriginSelf = "self"
 
 	// HeaderValueWildcard represents the wildcard CORS response, which allows any method,
 	// header, or origin.
 	// See: Fetch Standard § 3.2.4. HTTP new-header syntax.
 	HeaderValueWildcard = "*"
 
+	// HeaderAccept, HeaderAcceptLanguage, HeaderContentLanguage,
+	// HeaderContentType and HeaderRange are the CORS-safelisted request
+	// headers, which browsers send without listing them in
+	// Access-Control-Request-Headers as long as their values are simple.
+	// See: Fetch Standard § 2.2.2. Headers.
+	HeaderAccept          = "Accept"
+	HeaderAcceptLanguage  = "Accept-Language"
+	HeaderContentLanguage = "Content-Language"
+	HeaderContentType     = "Content-Type"
+	HeaderRange           = "Range"
+
+	// ContentTypeGRPC is the media type of native gRPC requests, which never
+	// come from browsers and are skipped by default.
+	ContentTypeGRPC = "application/grpc"
+
+	// ListSeparator is the default separator between the values of list
+	// headers such as Access-Control-Allow-Headers.
+	ListSeparator = ", "
+	// ListSeparatorCompact separates list header values without whitespace,
+	// for legacy clients that do not accept optional whitespace.
+	ListSeparatorCompact = ","
+
+	// DefaultOriginCacheSize is the default number of origins whose pattern
+	// matching result is cached by a handler.
+	DefaultOriginCacheSize = 1024
+
+	// DefaultMaxOriginLength is the default maximum length of an accepted
+	// Origin header. It comfortably fits a 253 byte host name with its scheme
+	// and port.
+	DefaultMaxOriginLength = 300
+
+	// DefaultDeniedOriginCacheSize is the default number of recently denied
+	// origins remembered by a handler.
+	DefaultDeniedOriginCacheSize = 256
+	// DefaultDeniedOriginCacheTTL is the default time a denied origin is
+	// remembered by a handler.
+	DefaultDeniedOriginCacheTTL = 10 * time.Second
+
+	// DefaultMaxPreflightHeaderCount is the default maximum number of header
+	// values of an accepted preflight request.
+	DefaultMaxPreflightHeaderCount = 64
+	// DefaultMaxPreflightBodyBytes is the default maximum body size of an
+	// accepted preflight request.
+	DefaultMaxPreflightBodyBytes = 8 << 10
+	// DefaultMaxRequestedHeaders is the default maximum number of headers
+	// listed in the Access-Control-Request-Headers of an accepted preflight.
+	DefaultMaxRequestedHeaders = 32
+
+	// DefaultForwardOriginHeader is the default request header receiving the
+	// Origin when ForwardOrigin is set.
+	DefaultForwardOriginHeader = "X-Forwarded-Origin"
+
+	// DefaultDebugHeader is the default response header summing up decisions
+	// when Debug is set.
+	DefaultDebugHeader = "X-Cors-Debug"
+
 	// DefaultMaxAge is the default time that a client should cache a
 	// CORS preflight response.
 	// See: Fetch Standard § 3.2.3. HTTP responses.
 	DefaultMaxAge = 5
+	// DefaultMaxAgeCeiling is the default ceiling of the emitted MaxAge: no
+	// browser caches a preflight response for longer than a day.
+	DefaultMaxAgeCeiling = 86400
 )
 
 // Request represents a CORS request, which may or may not be a preflight request.
 type Request http.Request
 
-// IsPreflight determines if a request is a CORS preflight request.
+// IsPreflight determines if a request is a CORS preflight request: an OPTIONS
+// request with an Origin and an Access-Control-Request-Method header. Browsers
+// omit Access-Control-Request-Headers when no non-safelisted headers are
+// requested, so it is not required, and a present but empty one does not make
+// a request any less of a preflight: see LookupRequestedHeaders.
 // See: Fetch Standard § 3.2.2. HTTP requests.
 func (r *Request) IsPreflight() bool {
 	return r.Method == http.MethodOptions &&
-		r.Header.Get(HeaderOrigin) != "" &&
-		r.Header.Get(HeaderRequestMethod) != "" &&
-		r.Header.Get(HeaderRequestHeaders) != ""
+		r.origin() != "" &&
+		r.Header.Get(HeaderRequestMethod) != ""
+}
+
+// RequestedMethod returns the method of the request's
+// Access-Control-Request-Method header, without surrounding whitespace, or ""
+// when there is none. It is safe to call on a nil Request.
+func (r *Request) RequestedMethod() string {
+	if r == nil {
+		return ""
+	}
+
+	return normalize.TrimOWS(r.Header.Get(HeaderRequestMethod))
+}
+
+// RequestedHeaders returns the header names listed by the request's
+// Access-Control-Request-Headers fields, as parsed by ParseRequestHeaders, or
+// an empty slice when there are none. It is safe to call on a nil Request.
+// Nothing is memoized: each call parses the fields again, which costs one
+// allocation per call when headers are requested and none otherwise.
+func (r *Request) RequestedHeaders() []string {
+	names, _ := r.LookupRequestedHeaders()
+	if names == nil {
+		return []string{}
+	}
+
+	return names
+}
+
+// LookupRequestedHeaders is like RequestedHeaders, but tells an absent
+// Access-Control-Request-Headers, which requests no header, from a present one
+// listing no header, which browsers never send: the former gives nil and
+// false, the latter an empty slice and true.
+func (r *Request) LookupRequestedHeaders() ([]string, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	values, ok := r.Header[HeaderRequestHeaders]
+	if !ok {
+		return nil, false
+	}
+
+	names := ParseRequestHeaders(values...)
+	if names == nil {
+		names = []string{}
+	}
+
+	return names, true
+}
+
+// origin returns the request's Origin header. An empty or whitespace-only
+// value, as sent by some privacy extensions, is treated as no Origin at all.
+func (r *Request) origin() string {
+	origin := r.Header.Get(HeaderOrigin)
+	if strings.TrimSpace(origin) == "" {
+		return ""
+	}
+
+	return origin
 }
 
 // Options represents the potential CORS options a server can return to its clients.
@@ -80,7 +241,300 @@ type Options struct {
 	ExposeHeaders    []string
 	MaxAge           int
 
-	cache map[string]string
+	// ExposeHeadersCredentialFallback lists the headers exposed to requests
+	// with credentials when ExposeHeaders holds "*" and AllowCredentials is
+	// set, since these requests do not honor the wildcard.
+	ExposeHeadersCredentialFallback []string
+	// KeepForbiddenExposeHeaders sends forbidden response-header names, such
+	// as Set-Cookie, in Access-Control-Expose-Headers as configured. Browsers
+	// never expose them, so they are left out otherwise, and Validate reports
+	// them.
+	KeepForbiddenExposeHeaders bool
+	// AutoReflect translates every wildcard into its equivalent for requests
+	// with credentials when AllowCredentials is set, since browsers take the
+	// wildcard literally for them: the wildcard ExposeHeaders requires
+	// ExposeHeadersCredentialFallback, beside the wildcard AllowOrigins,
+	// AllowMethods and AllowHeaders, which always echo the Origin, the
+	// requested method and the requested headers.
+	AutoReflect bool
+	// MaxAgeCeiling clamps MaxAge, since browsers ignore longer durations:
+	// Chrome caches preflight responses for two hours at most and Firefox for
+	// a day. Zero emits MaxAge as is.
+	MaxAgeCeiling int
+	// MaxAgeDuration is MaxAge as a duration, rounded down to whole seconds.
+	// It takes precedence over MaxAge when not zero, and a negative duration
+	// omits the header like a negative MaxAge does.
+	MaxAgeDuration time.Duration
+	// AllowLocalhost allows any loopback origin (localhost, *.localhost,
+	// 127.0.0.0/8 and [::1]) on any scheme and port. It is meant for local
+	// development and should be left disabled in production.
+	AllowLocalhost bool
+	// AllowOriginCIDRs allows origins whose host is an IP literal inside one of
+	// the listed CIDR blocks, on any scheme and port. Hostname origins never
+	// match these entries.
+	AllowOriginCIDRs []string
+	// AllowDomains allows https origins whose host is one of the listed apex
+	// domains, such as "example.com", or any of their subdomains, on any port.
+	// Public suffixes such as "co.uk" are refused by Validate.
+	AllowDomains []string
+	// AllowDomainsAnyScheme lets AllowDomains match origins of any scheme
+	// instead of https only.
+	AllowDomainsAnyScheme bool
+	// AllowPublicSuffixPatterns accepts AllowOrigins and TimingAllowOrigins
+	// patterns wildcarding a top-level domain or a public suffix, such as
+	// "https://*.github.io", which Validate refuses otherwise. The suffixes
+	// are a short, hand-maintained list rather than the Public Suffix List,
+	// so patterns of unlisted suffixes are accepted either way.
+	AllowPublicSuffixPatterns bool
+	// AllowExtensionIDs allows browser extensions by ID. Each ID is expanded to
+	// the chrome-extension://, moz-extension:// and safari-web-extension://
+	// origins. IDs are matched case-sensitively.
+	AllowExtensionIDs []string
+	// OriginMatchers are consulted, in order, for origins not allowed by any of
+	// the above. They must be set before NewHandler is called.
+	OriginMatchers []OriginMatcher
+	// TimingAllowOrigins lists the origins, patterns or "*" allowed to read
+	// the Resource Timing data of actual responses, through the
+	// Timing-Allow-Origin header. It is independent of AllowOrigins.
+	TimingAllowOrigins []string
+	// ResourcePolicy is the Cross-Origin-Resource-Policy of actual responses,
+	// "same-origin", "same-site" or "cross-origin", which pages enforcing
+	// Cross-Origin-Embedder-Policy require to embed them. It is sent whether
+	// or not the request has an Origin, and omitted when empty.
+	ResourcePolicy string
+	// SelfScheme is the scheme assumed for the request's own origin, used by
+	// the OriginSelf keyword and SuppressSameOriginHeaders. When empty, it is
+	// taken from the forwarded headers of a trusted proxy or the request's TLS
+	// state.
+	SelfScheme string
+	// TrustedProxies lists the IP addresses or CIDR blocks of the proxies whose
+	// Forwarded, X-Forwarded-Proto and X-Forwarded-Host headers are believed
+	// when computing the request's own origin. Forwarded headers of any other
+	// client are ignored.
+	TrustedProxies []string
+	// SuppressSameOriginHeaders skips all CORS response headers on requests
+	// whose Origin equals the request's own origin. Browsers send an Origin
+	// on same-origin POST requests, which do not need CORS headers. Preflight
+	// requests are never same-origin and are not affected.
+	SuppressSameOriginHeaders bool
+	// OriginCacheSize is the number of origins whose AllowOrigins pattern
+	// matching result is cached by a handler, least recently used first out.
+	// Zero disables the cache. Literal origins and OriginMatchers are never
+	// cached.
+	OriginCacheSize int
+	// DeniedOriginCacheSize is the number of recently denied origins remembered
+	// by a handler, so repeated requests from them skip matching entirely.
+	// Zero disables the cache. It is not used with the OriginSelf keyword,
+	// whose result depends on the request's Host.
+	DeniedOriginCacheSize int
+	// DeniedOriginCacheTTL is the time a denied origin is remembered, bounding
+	// how long an OriginMatcher that starts allowing it is ignored.
+	DeniedOriginCacheTTL time.Duration
+	// PreflightCacheSize is the number of preflight outcomes, by allowed
+	// origin, requested method and requested headers, whose response headers
+	// are cached by a handler, least recently used first out. Zero, the
+	// default, disables the cache.
+	PreflightCacheSize int
+	// HeaderListSeparator separates the values of every list header written by
+	// the handler. It must be ListSeparator or ListSeparatorCompact; empty
+	// means ListSeparator.
+	HeaderListSeparator string
+	// VaryExtra lists header names merged into the Vary header of every
+	// response the handler adds CORS headers to, and of responses to requests
+	// without an Origin, such as the name of a header set by an earlier
+	// middleware that the response also depends on.
+	VaryExtra []string
+	// SkipContentTypes lists request media types for which CORS processing is
+	// bypassed entirely. An entry also matches structured syntax suffixes, so
+	// "application/grpc" matches "application/grpc+proto" but not
+	// "application/grpc-web".
+	SkipContentTypes []string
+	// StrictMode makes the handler fail closed on internal errors, such as a
+	// panicking OriginMatcher: the request is answered with StrictModeStatus
+	// instead of being processed without CORS headers.
+	StrictMode bool
+	// StrictModeStatus is the status code used by StrictMode. Zero means
+	// http.StatusInternalServerError.
+	StrictModeStatus int
+	// ErrorLog receives the internal errors StrictMode fails requests on,
+	// which Stats counts as StrictModeRejections. Nil means the standard
+	// logger of the log package.
+	ErrorLog *log.Logger
+	// RequireSecureOrigins rejects every Origin that is not https before
+	// AllowOrigins is consulted, except loopback origins so local development
+	// keeps working. No CORS headers are written for a rejected Origin.
+	RequireSecureOrigins bool
+	// MaxOriginLength is the maximum length in bytes of an accepted Origin.
+	// Requests with a longer Origin are processed as non-CORS requests before
+	// any matching, and counted in Stats. Zero means no limit.
+	MaxOriginLength int
+	// EnforceMethods fails preflight requests whose Access-Control-Request-Method
+	// is neither a CORS-safelisted method nor listed in AllowMethods, instead of
+	// leaving the denial to the browser. Methods are compared case-sensitively,
+	// and the wildcard allows any method.
+	EnforceMethods bool
+	// IncludeDefaultMethods adds GET, HEAD and OPTIONS to the methods of
+	// AllowMethods, both in Access-Control-Allow-Methods and for
+	// EnforceMethods, without modifying AllowMethods.
+	IncludeDefaultMethods bool
+	// ReflectRequestMethods echoes the Access-Control-Request-Method of
+	// preflight requests as their Access-Control-Allow-Methods, and allows any
+	// valid method for EnforceMethods, when AllowMethods is empty. Responses
+	// then vary on Access-Control-Request-Method. It has no effect otherwise.
+	ReflectRequestMethods bool
+	// EnforceHeaders fails preflight requests whose Access-Control-Request-Headers
+	// lists a header that is neither CORS-safelisted nor listed in AllowHeaders.
+	// Header names are compared case-insensitively, and the wildcard allows any
+	// header but Authorization, or any header at all when AllowCredentials is
+	// set and the requested headers are echoed.
+	EnforceHeaders bool
+	// IncludeAuthorizationWithWildcard adds Authorization to the wildcard
+	// Access-Control-Allow-Headers of preflight requests asking for it, since
+	// the wildcard never covers it, and lets EnforceHeaders allow it.
+	IncludeAuthorizationWithWildcard bool
+	// ReflectRequestHeaders echoes the valid names of the
+	// Access-Control-Request-Headers of preflight requests, in lower case, as
+	// their Access-Control-Allow-Headers, and allows them for EnforceHeaders,
+	// when AllowHeaders is empty. Responses then vary on
+	// Access-Control-Request-Headers. It has no effect otherwise.
+	ReflectRequestHeaders bool
+	// PartialAllowHeaders answers preflight requests with the requested headers
+	// that are allowed, instead of the Access-Control-Allow-Headers built from
+	// AllowHeaders, and keeps EnforceHeaders from failing preflight requests
+	// for the others, leaving the decision to the browser. Responses then vary
+	// on Access-Control-Request-Headers.
+	PartialAllowHeaders bool
+	// AllowHeadersByMethod maps methods, or comma-separated lists of methods,
+	// to the headers allowed to preflight requests for them in place of
+	// AllowHeaders, such as an idempotency key only allowed for POST and PUT.
+	// Preflight requests for other methods use AllowHeaders. Responses then
+	// vary on Access-Control-Request-Method.
+	AllowHeadersByMethod map[string][]string
+	// IncludeSafelistedHeaders makes EnforceHeaders allow the CORS-safelisted
+	// request headers, such as HeaderContentType, without listing them in
+	// AllowHeaders. They are not added to Access-Control-Allow-Headers.
+	IncludeSafelistedHeaders bool
+	// PreflightStatus is the status code of successful preflight responses,
+	// which must be in the 2xx range. Zero means http.StatusNoContent; some
+	// older clients and probes expect http.StatusOK.
+	PreflightStatus int
+	// DeniedPreflightStatus is the status code of preflight requests whose
+	// Origin is not allowed, which carry no CORS headers so the policy is not
+	// disclosed to arbitrary sites. Zero means http.StatusNoContent.
+	DeniedPreflightStatus int
+	// PreflightFailureStatus is the status code of failed preflight requests,
+	// which carry no CORS headers. Zero means http.StatusForbidden.
+	PreflightFailureStatus int
+	// MaxRequestedHeaders is the maximum number of headers a preflight request
+	// may list in Access-Control-Request-Headers. Longer lists fail the
+	// preflight before any header is inspected. Zero means no limit.
+	MaxRequestedHeaders int
+	// MaxPreflightHeaderCount is the maximum number of header values of a
+	// preflight request. Larger preflights are answered with
+	// PreflightHeaderCountStatus before any matching. Zero means no limit.
+	MaxPreflightHeaderCount int
+	// PreflightHeaderCountStatus is the status code answering preflights over
+	// MaxPreflightHeaderCount. Zero means
+	// http.StatusRequestHeaderFieldsTooLarge.
+	PreflightHeaderCountStatus int
+	// MaxPreflightBodyBytes is the maximum body size of a preflight request.
+	// Larger preflights are answered with PreflightBodyStatus before any
+	// matching, reading at most one byte past the limit. Zero means no limit.
+	MaxPreflightBodyBytes int64
+	// PreflightBodyStatus is the status code answering preflights over
+	// MaxPreflightBodyBytes. Zero means http.StatusRequestEntityTooLarge.
+	PreflightBodyStatus int
+	// StripOriginHeader removes the Origin header from the request passed on
+	// by NewMiddleware, once the CORS response headers have been decided, for
+	// backends that react badly to it. The middleware's own request is not
+	// modified.
+	StripOriginHeader bool
+	// StripRequestHeaders also removes the Access-Control-Request-Method and
+	// Access-Control-Request-Headers headers when StripOriginHeader is set.
+	StripRequestHeaders bool
+	// ForwardOrigin copies the request's Origin into the ForwardOriginHeader
+	// of the request passed on by NewMiddleware, replacing any value sent by
+	// the client, even when StripOriginHeader is set.
+	ForwardOrigin bool
+	// ForwardOriginHeader is the request header used by ForwardOrigin. Empty
+	// means DefaultForwardOriginHeader.
+	ForwardOriginHeader string
+	// ParanoidChecks refuses to allow the Origin of requests showing signs of
+	// a malformed header block, such as conflicting Origin headers or an
+	// ambiguous body length.
+	ParanoidChecks bool
+	// PassthroughPreflight passes successful preflight requests on to the next
+	// handler of NewMiddleware with the CORS headers already written, instead
+	// of terminating them, for backends adding their own preflight logic.
+	// CORS headers the backend adds replace those of the middleware. Failed
+	// preflights are still terminated.
+	PassthroughPreflight bool
+	// LenientPreflight also answers OPTIONS requests with an Origin but no
+	// Access-Control-Request-Method as preflight requests, for non-conforming
+	// clients expecting it, instead of passing them on as actual requests.
+	// Such requests are not checked by EnforceMethods.
+	LenientPreflight bool
+	// PreserveExistingHeaders keeps the Access-Control-* headers set by others:
+	// the middleware does not set a header already present on the response,
+	// and, for requests passed on by NewMiddleware, a header the next handler
+	// sets or adds to replaces the one of the middleware.
+	PreserveExistingHeaders bool
+	// HeaderPhase is when NewMiddleware writes the CORS headers of requests
+	// passed on: before calling the next handler under HeaderPhaseRequest,
+	// the default, or once the next handler writes its response under
+	// HeaderPhaseResponse, so they replace or merge with those it set.
+	HeaderPhase string
+	// StripUpstreamCORSHeaders deletes the Access-Control-* headers the next
+	// handler set, such as those of a framework's own CORS support, before
+	// the middleware writes its own, so browsers do not get duplicate values.
+	// It requires HeaderPhaseResponse.
+	StripUpstreamCORSHeaders bool
+	// StripManagedHeadersOnly scopes StripUpstreamCORSHeaders to the headers
+	// the middleware writes for the response, keeping the others.
+	StripManagedHeadersOnly bool
+	// OptionsPassthrough passes OPTIONS requests that are not preflights, such
+	// as capability discovery requests, on to the next handler of
+	// NewMiddleware. When false, the middleware answers them itself with an
+	// Allow header listing AllowMethods and OPTIONS.
+	OptionsPassthrough bool
+	// ExplainDeniedPreflights writes a short text/plain explanation, such as
+	// "not-allowed: origin \"https://example.com\" is not allowed", in the body
+	// of denied and failed preflight responses whose status allows a body.
+	// It discloses the policy to any site, so it is meant for debugging only.
+	ExplainDeniedPreflights bool
+	// Debug sets the DebugHeader of preflight and actual responses to CORS
+	// requests to a compact summary of the decision, such as
+	// "origin=denied(not-allowed)" or "preflight=allowed;method=PUT;hdrs=2",
+	// to be read from the browser's developer tools. It never holds entries of
+	// the configuration, but still tells any site why it is denied, so it is
+	// meant for debugging only.
+	Debug bool
+	// DebugHeader is the response header used by Debug. Empty means
+	// DefaultDebugHeader.
+	DebugHeader string
+	// PreflightResponder terminates preflight requests. When nil,
+	// DefaultPreflightResponder is used.
+	PreflightResponder PreflightResponder
+
+	cache      map[string]string
+	cidrs      []*net.IPNet
+	domains    []string
+	proxies    []*net.IPNet
+	methods    []string
+	headers    map[string]string
+	origins    map[string]struct{}
+	patterns   []Pattern
+	matched    *lru
+	denied     *lru
+	preflights *lru
+	stats      *stats
+	wildcard   bool
+	self       bool
+
+	timing         []Pattern
+	timingWildcard bool
+
+	byMethod map[string]*Options
 }
 
 // NewOptions returns a properly initialized Options pointer.
@@ -92,11 +546,264 @@ func NewOptions() *Options {
 		AllowOrigins:     []string{},
 		ExposeHeaders:    []string{},
 		MaxAge:           DefaultMaxAge,
+		MaxAgeCeiling:    DefaultMaxAgeCeiling,
+		MaxAgeDuration:   0,
+		AllowLocalhost:   false,
+		AllowOriginCIDRs: []string{},
+		OriginMatchers:   []OriginMatcher{},
+
+		AllowDomains:                     []string{},
+		AllowDomainsAnyScheme:            false,
+		AllowPublicSuffixPatterns:        false,
+		AllowExtensionIDs:                []string{},
+		TimingAllowOrigins:               []string{},
+		ExposeHeadersCredentialFallback:  []string{},
+		KeepForbiddenExposeHeaders:       false,
+		AutoReflect:                      false,
+		ResourcePolicy:                   "",
+		SelfScheme:                       "",
+		TrustedProxies:                   []string{},
+		SuppressSameOriginHeaders:        false,
+		OriginCacheSize:                  DefaultOriginCacheSize,
+		DeniedOriginCacheSize:            DefaultDeniedOriginCacheSize,
+		DeniedOriginCacheTTL:             DefaultDeniedOriginCacheTTL,
+		PreflightCacheSize:               0,
+		HeaderListSeparator:              ListSeparator,
+		VaryExtra:                        []string{},
+		SkipContentTypes:                 []string{ContentTypeGRPC},
+		StrictMode:                       false,
+		StrictModeStatus:                 http.StatusInternalServerError,
+		ErrorLog:                         nil,
+		RequireSecureOrigins:             false,
+		MaxOriginLength:                  DefaultMaxOriginLength,
+		EnforceMethods:                   false,
+		IncludeDefaultMethods:            false,
+		ReflectRequestMethods:            false,
+		EnforceHeaders:                   false,
+		IncludeSafelistedHeaders:         true,
+		IncludeAuthorizationWithWildcard: false,
+		ReflectRequestHeaders:            false,
+		PartialAllowHeaders:              false,
+		AllowHeadersByMethod:             map[string][]string{},
+		PreflightStatus:                  http.StatusNoContent,
+		DeniedPreflightStatus:            http.StatusNoContent,
+		PreflightFailureStatus:           http.StatusForbidden,
+		MaxRequestedHeaders:              DefaultMaxRequestedHeaders,
+		MaxPreflightHeaderCount:          DefaultMaxPreflightHeaderCount,
+		PreflightHeaderCountStatus:       http.StatusRequestHeaderFieldsTooLarge,
+		MaxPreflightBodyBytes:            DefaultMaxPreflightBodyBytes,
+		PreflightBodyStatus:              http.StatusRequestEntityTooLarge,
+		StripOriginHeader:                false,
+		StripRequestHeaders:              false,
+		ForwardOrigin:                    false,
+		ForwardOriginHeader:              DefaultForwardOriginHeader,
+		ParanoidChecks:                   false,
+		PassthroughPreflight:             false,
+		LenientPreflight:                 false,
+		PreserveExistingHeaders:          false,
+		HeaderPhase:                      HeaderPhaseRequest,
+		StripUpstreamCORSHeaders:         false,
+		StripManagedHeadersOnly:          false,
+		OptionsPassthrough:               true,
+		Debug:                            false,
+		DebugHeader:                      DefaultDebugHeader,
+		PreflightResponder:               nil,
+
+		cache:      nil,
+		cidrs:      nil,
+		domains:    nil,
+		proxies:    nil,
+		methods:    nil,
+		headers:    nil,
+		origins:    nil,
+		patterns:   nil,
+		matched:    nil,
+		denied:     nil,
+		preflights: nil,
+		stats:      nil,
+		wildcard:   false,
+		self:       false,
+
+		timing:         nil,
+		timingWildcard: false,
 
-		cache: nil,
+		byMethod: nil,
 	}
 }
 
+// Validate reports the first configuration error found in the Options, such
+// as an AllowOrigins entry that is not a valid pattern, an entry of
+// AllowOriginCIDRs that cannot be parsed or an AllowMethods entry that is not
+// a method. A nil error means the Options can safely be used to create a
+// handler.
+func (o *Options) Validate() error {
+	for _, validate := range []func() error{
+		o.validateOrigins,
+		o.validateOriginSources,
+		o.validateExposeHeaders,
+		o.validateMethods,
+		o.validateHeaderNames,
+		o.validateResponses,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateOrigins validates the patterns of AllowOrigins and
+// TimingAllowOrigins.
+func (o *Options) validateOrigins() error {
+	for _, ao := range normalize.List(o.AllowOrigins) {
+		if ao == HeaderValueWildcard || ao == OriginSelf {
+			continue
+		}
+
+		if _, err := o.compile(ao); err != nil {
+			return fmt.Errorf("allowed origin: %w", err)
+		}
+	}
+
+	for _, to := range o.TimingAllowOrigins {
+		if to == HeaderValueWildcard {
+			continue
+		}
+
+		if _, err := o.compile(to); err != nil {
+			return fmt.Errorf("timing allowed origin: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validateOriginSources validates the origins allowed besides AllowOrigins,
+// and the proxies trusted to forward the request's own origin.
+func (o *Options) validateOriginSources() error {
+	for _, d := range o.AllowDomains {
+		if err := validateDomain(d); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range o.AllowExtensionIDs {
+		if !isExtensionID(id) {
+			return fmt.Errorf("invalid extension ID %q", id)
+		}
+	}
+
+	for _, c := range o.AllowOriginCIDRs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return fmt.Errorf("invalid origin CIDR %q: %w", c, err)
+		}
+	}
+
+	for _, p := range o.TrustedProxies {
+		if _, err := parseProxy(p); err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// validateExposeHeaders validates ExposeHeaders and
+// ExposeHeadersCredentialFallback.
+func (o *Options) validateExposeHeaders() error {
+	if !o.KeepForbiddenExposeHeaders {
+		for _, list := range [][]string{o.ExposeHeaders, o.ExposeHeadersCredentialFallback} {
+			for _, name := range list {
+				if IsForbiddenResponseHeader(name) {
+					return fmt.Errorf("exposed header %q is a forbidden response-header name browsers never expose",
+						normalize.TrimOWS(name))
+				}
+			}
+		}
+	}
+
+	if o.AutoReflect && o.AllowCredentials && o.exposesWildcard() && len(o.credentialExposeHeaders()) == 1 {
+		return fmt.Errorf("auto reflect: exposed header %q requires an explicit ExposeHeadersCredentialFallback with credentials",
+			HeaderValueWildcard)
+	}
+
+	return nil
+}
+
+// validateMethods validates the methods of AllowMethods and the keys of
+// AllowHeadersByMethod.
+func (o *Options) validateMethods() error {
+	for _, entry := range o.AllowMethods {
+		for _, m := range methodList(entry) {
+			if !IsMethod(m) {
+				return fmt.Errorf("invalid allowed method %q", m)
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(o.AllowHeadersByMethod) {
+		for _, m := range methodList(key) {
+			if !IsMethod(m) || m == HeaderValueWildcard {
+				return fmt.Errorf("invalid method %q of allowed headers by method", m)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateHeaderNames validates VaryExtra, DebugHeader and
+// HeaderListSeparator.
+func (o *Options) validateHeaderNames() error {
+	for _, name := range o.VaryExtra {
+		if name = normalize.TrimOWS(name); name != "" && (!normalize.IsToken(name) || name == HeaderValueWildcard) {
+			return fmt.Errorf("invalid vary header name %q", name)
+		}
+	}
+
+	if o.DebugHeader != "" && !normalize.IsToken(o.DebugHeader) {
+		return fmt.Errorf("invalid debug header name %q", o.DebugHeader)
+	}
+
+	switch o.HeaderListSeparator {
+	case "", ListSeparator, ListSeparatorCompact:
+	default:
+		return fmt.Errorf("invalid header list separator %q: must be %q or %q",
+			o.HeaderListSeparator, ListSeparator, ListSeparatorCompact)
+	}
+
+	return nil
+}
+
+// validateResponses validates ResourcePolicy, HeaderPhase and
+// PreflightStatus.
+func (o *Options) validateResponses() error {
+	switch o.ResourcePolicy {
+	case "", ResourcePolicySameOrigin, ResourcePolicySameSite, ResourcePolicyCrossOrigin:
+	default:
+		return fmt.Errorf("invalid resource policy %q: must be %q, %q or %q", o.ResourcePolicy,
+			ResourcePolicySameOrigin, ResourcePolicySameSite, ResourcePolicyCrossOrigin)
+	}
+
+	switch o.HeaderPhase {
+	case "", HeaderPhaseRequest, HeaderPhaseResponse:
+	default:
+		return fmt.Errorf("invalid header phase %q: must be %q or %q", o.HeaderPhase,
+			HeaderPhaseRequest, HeaderPhaseResponse)
+	}
+
+	if o.StripUpstreamCORSHeaders && o.HeaderPhase != HeaderPhaseResponse {
+		return fmt.Errorf("stripping upstream CORS headers requires header phase %q", HeaderPhaseResponse)
+	}
+
+	if o.PreflightStatus != 0 && (o.PreflightStatus < 200 || o.PreflightStatus > 299) {
+		return fmt.Errorf("invalid preflight status %d: must be 2xx", o.PreflightStatus)
+	}
+
+	return nil
+}
+
 // GetAllowOrigin returns the appropriate Access-Control-Allow-Origin header.
 // If the wildcard is present, it will be used instead of the request's
 // Origin header. An empty string represents that no Access-Control-Allow-Origin
@@ -105,22 +812,231 @@ func NewOptions() *Options {
 // See: Fetch Standard § 3.2.3. HTTP responses.
 //
 // If the client's credentials mode is "include", wildcard values will result in
-// a client side failure.
+// a client side failure. Because of this, when AllowCredentials is set the
+// request's Origin header is returned in place of the wildcard.
 // See: Fetch Standard § 3.2.5. CORS protocol and credentials.
+//
+// An OriginMatcher that panics is treated as not matching, and no origin is
+// allowed when it exceeds MaxOriginLength, RequireSecureOrigins rejects it or
+// ParanoidChecks finds the request inconsistent.
 func (o *Options) GetAllowOrigin(request *Request) string {
-	origin := request.Header.Get(HeaderOrigin)
-	result := ""
+	result, _ := o.allowOrigin(request)
 
-	for _, ao := range o.AllowOrigins {
-		switch ao {
-		case HeaderValueWildcard:
-			return HeaderValueWildcard
-		case origin:
-			result = origin
+	return result
+}
+
+// allowOrigin implements GetAllowOrigin, also returning the error recovered
+// from a panicking OriginMatcher.
+func (o *Options) allowOrigin(request *Request) (string, error) {
+	origin := request.origin()
+
+	if o.oversizedOrigin(request) || o.rejectsInsecureOrigin(request) ||
+		o.ParanoidChecks && inconsistentRequest(request) {
+		return "", nil
+	}
+
+	if o.deniedRecently(origin) {
+		return "", nil
+	}
+
+	listed, wildcard := o.listsOrigin(request)
+	if wildcard {
+		return o.wildcardOrigin(origin), nil
+	}
+
+	if listed || o.AllowLocalhost && isLoopbackOrigin(origin) ||
+		o.matchesCIDR(origin) || o.matchesDomain(origin) {
+		return origin, nil
+	}
+
+	matched, err := o.matchesOriginMatcher(origin)
+	if matched {
+		return origin, nil
+	}
+
+	if err == nil {
+		o.rememberDenied(origin)
+	}
+
+	return "", err
+}
+
+// deniedRecently reports whether origin was denied less than
+// DeniedOriginCacheTTL ago.
+func (o *Options) deniedRecently(origin string) bool {
+	if o.denied == nil || origin == "" {
+		return false
+	}
+
+	expires, ok := o.denied.get(origin)
+
+	return ok && time.Now().Before(expires.(time.Time))
+}
+
+// rememberDenied remembers that origin was denied, for DeniedOriginCacheTTL.
+func (o *Options) rememberDenied(origin string) {
+	if o.denied == nil || origin == "" {
+		return
+	}
+
+	o.denied.add(origin, time.Now().Add(o.DeniedOriginCacheTTL))
+}
+
+// matchesCompiled reports whether origin is allowed by the literal origins and
+// patterns compiled by NewHandler. Results that required parsing the origin
+// are cached when OriginCacheSize allows it.
+func (o *Options) matchesCompiled(origin string) bool {
+	if _, ok := o.origins[origin]; ok {
+		return true
+	}
+
+	if origin == "" {
+		return false
+	}
+
+	if o.matched == nil {
+		return o.matchesParsed(origin)
+	}
+
+	if matched, ok := o.matched.get(origin); ok {
+		return matched.(bool)
+	}
+
+	matched := o.matchesParsed(origin)
+	o.matched.add(origin, matched)
+
+	return matched
+}
+
+// matchesParsed reports whether the canonical form of origin is a literal
+// origin or matches a pattern compiled by NewHandler.
+func (o *Options) matchesParsed(origin string) bool {
+	parsed, err := ParseOrigin(origin)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := o.origins[parsed.String()]; ok {
+		return true
+	}
+
+	for _, p := range o.patterns {
+		if p.matchOrigin(parsed) {
+			return true
 		}
 	}
 
-	return result
+	return false
+}
+
+// matchesOriginMatcher reports whether any of the OriginMatchers allows origin.
+// Matching stops at the first matcher that panics, whose panic is returned as
+// an error wrapping ErrMatcherPanic.
+func (o *Options) matchesOriginMatcher(origin string) (bool, error) {
+	if origin == "" {
+		return false, nil
+	}
+
+	for _, m := range o.OriginMatchers {
+		matched, err := safeMatch(m, origin)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+
+	return false, nil
+}
+
+// matchesCIDR reports whether origin has an IP literal host inside one of the
+// AllowOriginCIDRs blocks. Blocks parsed by NewHandler are reused, otherwise
+// they are parsed on demand and invalid entries are skipped.
+func (o *Options) matchesCIDR(origin string) bool {
+	if len(o.AllowOriginCIDRs) == 0 {
+		return false
+	}
+
+	parsed, err := ParseOrigin(origin)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(parsed.Host)
+	if ip == nil {
+		return false
+	}
+
+	cidrs := o.cidrs
+	if cidrs == nil {
+		cidrs = parseCIDRs(o.AllowOriginCIDRs)
+	}
+
+	for _, n := range cidrs {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isLoopbackOrigin reports whether origin names a loopback host, regardless
+// of its scheme or port.
+func isLoopbackOrigin(origin string) bool {
+	parsed, err := ParseOrigin(origin)
+
+	return err == nil && parsed.IsLoopback()
+}
+
+// parseCIDRs parses every valid CIDR block in values, skipping invalid ones.
+// Use Options.Validate to report them.
+func parseCIDRs(values []string) []*net.IPNet {
+	cidrs := make([]*net.IPNet, 0, len(values))
+
+	for _, c := range values {
+		if _, n, err := net.ParseCIDR(c); err == nil {
+			cidrs = append(cidrs, n)
+		}
+	}
+
+	return cidrs
+}
+
+// skipsContentType reports whether the request's Content-Type is listed in
+// SkipContentTypes.
+func (o *Options) skipsContentType(r *Request) bool {
+	if len(o.SkipContentTypes) == 0 {
+		return false
+	}
+
+	ct := r.Header.Get(HeaderContentType)
+	if ct == "" {
+		return false
+	}
+
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+
+	ct = normalize.LowerASCII(normalize.TrimOWS(ct))
+
+	for _, skip := range o.SkipContentTypes {
+		skip = normalize.LowerASCII(skip)
+		if ct == skip || strings.HasPrefix(ct, skip+"+") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wildcardOrigin returns the Access-Control-Allow-Origin value for a wildcard
+// configuration, echoing origin when credentials are allowed.
+func (o *Options) wildcardOrigin(origin string) string {
+	if o.AllowCredentials && origin != "" {
+		return origin
+	}
+
+	return HeaderValueWildcard
 }
 
 // GetAllowCredentials returns the appropriate Access-Control-Allow-Credentials header.
@@ -147,14 +1063,44 @@ func (o *Options) GetAllowCredentials() string {
 // If the client's credentials mode is "include", wildcard values will result in
 // a client side failure.
 // See: Fetch Standard § 3.2.4. HTTP new-header syntax.
+//
+// Duplicate entries are only listed once, and GET, HEAD and OPTIONS are added
+// when IncludeDefaultMethods is set.
 func (o *Options) GetAllowMethods() string {
-	for _, am := range o.AllowMethods {
+	methods := o.allowMethods()
+
+	for _, am := range methods {
 		if am == HeaderValueWildcard {
 			return HeaderValueWildcard
 		}
 	}
 
-	return strings.Join(o.AllowMethods, ", ")
+	return o.joinList(methods)
+}
+
+// GetAllow returns the Allow header answering OPTIONS requests that are not
+// preflight requests, when OptionsPassthrough is not set. It lists the
+// explicit entries of AllowMethods, the wildcard being skipped, followed by
+// OPTIONS.
+// See: RFC7231 § 7.4.1. Allow.
+func (o *Options) GetAllow() string {
+	methods := make([]string, 0, len(o.AllowMethods)+1)
+	hasOptions := false
+
+	for _, m := range o.allowMethods() {
+		if m == HeaderValueWildcard {
+			continue
+		}
+
+		hasOptions = hasOptions || m == http.MethodOptions
+		methods = append(methods, m)
+	}
+
+	if !hasOptions {
+		methods = append(methods, http.MethodOptions)
+	}
+
+	return o.joinList(methods)
 }
 
 // GetAllowHeaders returns the appropriate Access-Control-Allow-Headers header.
@@ -165,16 +1111,19 @@ func (o *Options) GetAllowMethods() string {
 // See: Fetch Standard § 3.2.3. HTTP responses.
 //
 // If the client's credentials mode is "include", wildcard values will result in
-// a client side failure.
+// a client side failure, so the handler echoes the requested headers instead
+// when AllowCredentials is set.
 // See: Fetch Standard § 3.2.4. HTTP new-header syntax.
+//
+// Header names are canonicalized, as described in canonicalHeaders.
 func (o *Options) GetAllowHeaders() string {
 	for _, ah := range o.AllowHeaders {
-		if ah == HeaderValueWildcard {
+		if normalize.TrimOWS(ah) == HeaderValueWildcard {
 			return HeaderValueWildcard
 		}
 	}
 
-	return strings.Join(o.AllowHeaders, ", ")
+	return o.joinList(canonicalHeaders(o.AllowHeaders))
 }
 
 // GetMaxAge returns the appropriate Access-Control-Max-Age header. An empty
@@ -182,8 +1131,35 @@ func (o *Options) GetAllowHeaders() string {
 // to the client. The Access-Control-Max-Age header should be returned on
 // preflight requests.
 // See: Fetch Standard § 3.2.3. HTTP responses.
+//
+// A negative MaxAge omits the header, so browsers use their default of five
+// seconds, while zero is returned as "0" and disables preflight caching.
+//
+// A MaxAge over MaxAgeCeiling is clamped to it, and reported by Warnings.
+// MaxAgeDuration, when set, is used instead of MaxAge.
 func (o *Options) GetMaxAge() string {
-	return strconv.Itoa(o.MaxAge)
+	maxAge := o.maxAge()
+	if maxAge < 0 {
+		return ""
+	}
+
+	if o.MaxAgeCeiling > 0 && maxAge > o.MaxAgeCeiling {
+		return strconv.Itoa(o.MaxAgeCeiling)
+	}
+
+	return strconv.Itoa(maxAge)
+}
+
+// maxAge returns the MaxAge in seconds, MaxAgeDuration taking precedence.
+func (o *Options) maxAge() int {
+	switch {
+	case o.MaxAgeDuration < 0:
+		return -1
+	case o.MaxAgeDuration > 0:
+		return int(o.MaxAgeDuration / time.Second)
+	}
+
+	return o.MaxAge
 }
 
 // GetExposeHeaders returns the appropriate Access-Control-Expose-Headers header.
@@ -193,83 +1169,564 @@ func (o *Options) GetMaxAge() string {
 // returned on CORS requests that are not preflight requests.
 // See: Fetch Standard § 3.2.3. HTTP responses.
 //
-// If the client's credentials mode is "include", wildcard values will result in
-// a client side failure.
+// If the client's credentials mode is "include", the wildcard is the literal
+// header name "*" and exposes nothing. So when AllowCredentials is set, the
+// other ExposeHeaders entries and ExposeHeadersCredentialFallback are listed
+// after the wildcard, which other requests still honor.
 // See: Fetch Standard § 3.2.4. HTTP new-header syntax.
+//
+// Header names are canonicalized, as described in canonicalHeaders, and
+// forbidden response-header names, which browsers never expose, are left out
+// unless KeepForbiddenExposeHeaders is set.
 func (o *Options) GetExposeHeaders() string {
+	if !o.exposesWildcard() {
+		return o.joinList(o.exposable(canonicalHeaders(o.ExposeHeaders)))
+	}
+
+	if !o.AllowCredentials {
+		return HeaderValueWildcard
+	}
+
+	return o.joinList(o.credentialExposeHeaders())
+}
+
+// exposesWildcard reports whether ExposeHeaders holds the wildcard.
+func (o *Options) exposesWildcard() bool {
 	for _, em := range o.ExposeHeaders {
-		if em == HeaderValueWildcard {
-			return HeaderValueWildcard
+		if normalize.TrimOWS(em) == HeaderValueWildcard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// credentialExposeHeaders returns the wildcard followed by the other
+// ExposeHeaders entries and those of ExposeHeadersCredentialFallback, each
+// listed once.
+func (o *Options) credentialExposeHeaders() []string {
+	names := []string{HeaderValueWildcard}
+	seen := map[string]struct{}{HeaderValueWildcard: {}}
+
+	for _, list := range [][]string{o.ExposeHeaders, o.ExposeHeadersCredentialFallback} {
+		for _, name := range list {
+			name = normalize.CanonicalHeader(normalize.TrimOWS(name))
+			key := normalize.LowerASCII(name)
+
+			if _, ok := seen[key]; ok || name == "" {
+				continue
+			}
+
+			seen[key] = struct{}{}
+			names = append(names, name)
 		}
 	}
 
-	return strings.Join(o.ExposeHeaders, ", ")
+	return o.exposable(names)
+}
+
+// exposable returns names without the forbidden response-header names, unless
+// KeepForbiddenExposeHeaders is set. names may be modified.
+func (o *Options) exposable(names []string) []string {
+	if o.KeepForbiddenExposeHeaders {
+		return names
+	}
+
+	kept := names[:0]
+
+	for _, name := range names {
+		if !IsForbiddenResponseHeader(name) {
+			kept = append(kept, name)
+		}
+	}
+
+	return kept
+}
+
+// canonicalHeaders returns the configured header names in values in their
+// canonical form, such as "Content-Type" for "content-type", without
+// surrounding whitespace, empty entries or case-insensitive duplicates.
+// Browsers compare header names case-insensitively, but a consistent casing
+// keeps responses readable. Entries that are not valid header names, such as
+// "*", are kept as is.
+func canonicalHeaders(values []string) []string {
+	names := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+
+	for _, v := range values {
+		name := normalize.CanonicalHeader(normalize.TrimOWS(v))
+		key := normalize.LowerASCII(name)
+
+		if _, ok := seen[key]; ok || name == "" {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// joinList joins values with the configured HeaderListSeparator.
+func (o *Options) joinList(values []string) string {
+	sep := o.HeaderListSeparator
+	if sep == "" {
+		sep = ListSeparator
+	}
+
+	return strings.Join(values, sep)
 }
 
 // GetVary returns the appropriate Vary header. An empty string represents that
-// the Vary header should not be modified. The Vary header should include Origin
-// if the server has multiple allowed origins, unless the server uses the
-// wildcard origin.
+// the Vary header should not be modified. The Vary header includes Origin
+// whenever the Access-Control-Allow-Origin of a response depends on the
+// request's Origin: unless no origin is allowed at all, or the lone wildcard
+// origin is sent as is without credentials. Even a single allowed origin is
+// only sent to requests from it, so a shared cache must not serve that
+// response to requests from elsewhere. The same goes for a Timing-Allow-Origin
+// that is not the wildcard. The names of VaryExtra follow.
 // See: Fetch Standard § CORS protocol and HTTP caches.
 func (o *Options) GetVary() string {
-	if len(o.AllowOrigins) > 1 {
-		return HeaderOrigin
+	return o.joinList(canonicalHeaders(o.varyNames()))
+}
+
+// varyNames returns the names of the Vary header of GetVary, in order and
+// possibly repeated.
+func (o *Options) varyNames() []string {
+	var names []string
+
+	if o.variesOnOrigin() {
+		names = append(names, HeaderOrigin)
 	}
 
-	return ""
+	return append(names, o.VaryExtra...)
+}
+
+// variesOnOrigin reports whether responses vary on Origin, as described by
+// GetVary.
+func (o *Options) variesOnOrigin() bool {
+	if o.AllowLocalhost || len(o.AllowOriginCIDRs) > 0 || len(o.AllowDomains) > 0 ||
+		len(o.AllowExtensionIDs) > 0 || len(o.OriginMatchers) > 0 || o.variesOnTimingOrigin() {
+		return true
+	}
+
+	origins := normalize.List(o.AllowOrigins)
+	if len(origins) == 0 {
+		return false
+	}
+
+	return len(origins) > 1 || origins[0] != HeaderValueWildcard || o.AllowCredentials
+}
+
+// GetPreflightVary returns the appropriate Vary header of preflight responses:
+// the Vary header of GetVary, followed by Access-Control-Request-Method when
+// the response depends on it through EnforceMethods, AllowHeadersByMethod or
+// an echoed method, and Access-Control-Request-Headers when it depends on it
+// through EnforceHeaders or echoed headers, for any method. Each name is
+// listed once, even when VaryExtra repeats one of them. An empty string
+// represents that the Vary header should not be modified.
+func (o *Options) GetPreflightVary() string {
+	names := o.varyNames()
+
+	if o.EnforceMethods || o.reflectsMethods() || len(o.AllowHeadersByMethod) > 0 {
+		names = append(names, HeaderRequestMethod)
+	}
+
+	if o.EnforceHeaders || o.variesOnRequestHeaders() || o.scopeVariesOnRequestHeaders() {
+		names = append(names, HeaderRequestHeaders)
+	}
+
+	return o.joinList(canonicalHeaders(names))
+}
+
+// addVary merges the comma-separated names of values into the Vary header of
+// header, as a single field listing each name once, compared
+// case-insensitively. A Vary of "*" is left alone.
+// See: RFC9110 § 12.5.5. Vary.
+func (o *Options) addVary(header http.Header, values ...string) {
+	existing := header[HeaderVary]
+	if len(existing) == 0 && len(values) == 1 {
+		header[HeaderVary] = []string{values[0]}
+
+		return
+	}
+
+	var names []string
+
+	seen := make(map[string]struct{})
+
+	for _, v := range append(existing[:len(existing):len(existing)], values...) {
+		elements, _ := normalize.SplitList(v, -1)
+
+		for _, e := range elements {
+			key := normalize.LowerASCII(e)
+			if key == HeaderValueWildcard {
+				return
+			}
+
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				names = append(names, e)
+			}
+		}
+	}
+
+	header[HeaderVary] = []string{o.joinList(names)}
+}
+
+// varyPreflight is the key of the Vary header of preflight responses in the
+// cache built by NewHandler, which holds header values by name otherwise.
+const varyPreflight = "Vary (preflight)"
+
+// listsOrigin reports whether the request's Origin is allowed by AllowOrigins
+// or AllowExtensionIDs, and whether AllowOrigins holds the wildcard, using the
+// set built by NewHandler when there is one.
+func (o *Options) listsOrigin(request *Request) (listed, wildcard bool) {
+	origin := request.origin()
+
+	if o.origins != nil {
+		if o.wildcard {
+			return false, true
+		}
+
+		return o.matchesCompiled(origin) || o.self && o.isSameOrigin(request), false
+	}
+
+	for _, ao := range o.AllowOrigins {
+		if ao = normalize.TrimOWS(ao); ao == HeaderValueWildcard {
+			return false, true
+		}
+
+		if ao == OriginSelf {
+			if o.isSameOrigin(request) {
+				listed = true
+			}
+		} else if ao == origin {
+			listed = true
+		} else if p, err := o.compile(ao); err == nil && p.Match(origin) {
+			listed = true
+		}
+	}
+
+	for _, eo := range extensionOrigins(o.AllowExtensionIDs) {
+		if eo == origin {
+			listed = true
+		}
+	}
+
+	return listed, false
 }
 
 // NewHandler returns a http.Handler that can process CORS requests from the
-// provided Options.
+// provided Options. Invalid entries are ignored and duplicate entries are only
+// kept once; call Validate and Warnings first to report them. Once a handler
+// is created, GetAllowOrigin looks literal origins up in a precomputed set
+// instead of scanning AllowOrigins, so AllowOrigins must not be modified
+// without calling NewHandler again.
 func (o *Options) NewHandler() http.Handler {
 	o.cache = make(map[string]string)
+	o.cidrs = parseCIDRs(o.AllowOriginCIDRs)
+	o.domains = normalizeDomains(o.AllowDomains)
+	o.proxies = parseProxies(o.TrustedProxies)
+	o.methods = o.allowMethods()
+	o.headers = allowedHeaders(canonicalHeaders(o.AllowHeaders))
+	o.compileOrigins()
+	o.timing, o.timingWildcard = o.compileTimingOrigins()
+	o.stats = &stats{}
+	o.newCaches()
 
 	o.cache[HeaderAllowMethods] = o.GetAllowMethods()
-	o.cache[HeaderAllowHeaders] = o.GetAllowHeaders()
+	if !o.reflectsHeaders() {
+		o.cache[HeaderAllowHeaders] = o.GetAllowHeaders()
+	}
 	o.cache[HeaderExposeHeaders] = o.GetExposeHeaders()
 	o.cache[HeaderMaxAge] = o.GetMaxAge()
+	o.byMethod = o.scopeByMethod()
+	o.cache[HeaderVary] = o.GetVary()
+	o.cache[varyPreflight] = o.GetPreflightVary()
+	o.cache[HeaderAllow] = o.GetAllow()
 
 	return (*handler)(o)
 }
 
+// compileOrigins builds the set of literal origins and the list of patterns
+// of AllowOrigins and AllowExtensionIDs, noting the wildcard and the self
+// keyword. Entries that do not compile are kept as literals, so they only
+// match themselves.
+func (o *Options) compileOrigins() {
+	o.origins = make(map[string]struct{}, len(o.AllowOrigins))
+	o.patterns = nil
+	o.wildcard = false
+	o.self = false
+	compiled := make(map[string]bool)
+
+	for _, ao := range normalize.List(o.AllowOrigins) {
+		switch ao {
+		case HeaderValueWildcard:
+			o.wildcard = true
+
+			continue
+		case OriginSelf:
+			o.self = true
+
+			continue
+		}
+
+		p, err := o.compile(ao)
+
+		switch {
+		case err != nil:
+			o.origins[ao] = struct{}{}
+		case p.IsLiteral():
+			o.origins[p.key()] = struct{}{}
+		case !compiled[p.key()]:
+			compiled[p.key()] = true
+			o.patterns = append(o.patterns, p)
+		}
+	}
+
+	for _, eo := range extensionOrigins(o.AllowExtensionIDs) {
+		o.origins[eo] = struct{}{}
+	}
+}
+
+// newCaches creates the caches enabled by OriginCacheSize,
+// DeniedOriginCacheSize and PreflightCacheSize, once the origins are compiled.
+func (o *Options) newCaches() {
+	o.matched = nil
+	if o.OriginCacheSize > 0 && len(o.patterns) > 0 {
+		o.matched = newLRU(o.OriginCacheSize)
+	}
+
+	o.denied = nil
+	if o.DeniedOriginCacheSize > 0 && o.DeniedOriginCacheTTL > 0 && !o.wildcard && !o.self {
+		o.denied = newLRU(o.DeniedOriginCacheSize)
+	}
+
+	o.preflights = nil
+	if o.PreflightCacheSize > 0 {
+		o.preflights = newLRU(o.PreflightCacheSize)
+	}
+}
+
 type handler Options
 
 // ServeHTTP implements http.Handler for Options.
 func (h *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	h.serve(rw, req)
+}
+
+// serve writes the CORS headers for req and reports whether the response was
+// terminated, in which case the request must not be passed on.
+func (h *handler) serve(rw http.ResponseWriter, req *http.Request) bool {
 	o := (*Options)(h)
 	r := (*Request)(req)
 
-	if v := o.GetVary(); v != "" {
-		rw.Header().Add(HeaderVary, v)
+	if o.bypasses(rw, r) {
+		return false
 	}
 
-	if v := o.GetAllowOrigin(r); v != "" {
-		rw.Header().Set(HeaderAllowOrigin, v)
+	if status := o.oversizedPreflight(r); status != 0 {
+		atomic.AddUint64(&o.stats.oversizedPreflights, 1)
+		http.Error(rw, http.StatusText(status), status)
+
+		return true
 	}
 
-	if v := o.GetAllowCredentials(); v != "" {
-		rw.Header().Set(HeaderAllowCredentials, v)
+	d, err := o.decide(r)
+	if err != nil && o.StrictMode {
+		o.failStrict(rw, r, err)
+
+		return true
 	}
 
-	if r.IsPreflight() {
-		if v := o.cache[HeaderAllowMethods]; v != "" {
-			rw.Header().Set(HeaderAllowMethods, v)
+	preflight := o.isPreflight(r)
+
+	vary := o.cache[HeaderVary]
+	if preflight {
+		vary = o.cache[varyPreflight]
+	}
+
+	if vary != "" {
+		o.addVary(rw.Header(), vary)
+	}
+
+	if d.Reason == ReasonOversizedOrigin {
+		atomic.AddUint64(&o.stats.oversizedOrigins, 1)
+		o.setDebug(rw.Header(), r, d.Reason)
+
+		return false
+	}
+
+	if preflight {
+		return o.servePreflight(rw, r, d)
+	}
+
+	o.serveActual(rw, r, d)
+
+	return false
+}
+
+// bypasses reports whether r is passed on without CORS processing, as its
+// media type is skipped, it has no Origin or SuppressSameOriginHeaders
+// applies to it, after writing the headers such requests still get.
+func (o *Options) bypasses(rw http.ResponseWriter, r *Request) bool {
+	if o.skipsContentType(r) {
+		return true
+	}
+
+	// Embedding in no-cors mode sends no Origin, so the resource policy does
+	// not depend on it.
+	if o.ResourcePolicy != "" && !o.isPreflight(r) {
+		o.setHeader(rw.Header(), HeaderResourcePolicy, o.ResourcePolicy)
+	}
+
+	// Requests without an Origin, such as those of health checks and other
+	// servers, get no CORS headers at all. The response still varies on Origin
+	// so shared caches do not serve it to CORS requests.
+	if r.origin() == "" {
+		if v := o.cache[HeaderVary]; v != "" {
+			o.addVary(rw.Header(), v)
 		}
 
-		if v := o.cache[HeaderAllowHeaders]; v != "" {
-			rw.Header().Set(HeaderAllowHeaders, v)
+		return true
+	}
+
+	return o.SuppressSameOriginHeaders && !o.isPreflight(r) && o.isSameOrigin(r)
+}
+
+// failStrict answers r with StrictModeStatus on the internal error err.
+func (o *Options) failStrict(rw http.ResponseWriter, r *Request, err error) {
+	status := statusOr(o.StrictModeStatus, http.StatusInternalServerError)
+
+	atomic.AddUint64(&o.stats.strictModeRejections, 1)
+	o.logf("strict mode: %s %s from %q: %v", r.Method, r.URL.Path, r.origin(), err)
+
+	http.Error(rw, http.StatusText(status), status)
+}
+
+// servePreflight writes the response of the preflight request r, given the
+// decision d on its origin, and reports whether it was terminated.
+func (o *Options) servePreflight(rw http.ResponseWriter, r *Request, d Decision) bool {
+	if d.Reason != ReasonAllowed && d.Reason != ReasonNoOrigin {
+		o.denyPreflight(rw, r, statusOr(o.DeniedPreflightStatus, http.StatusNoContent), d.Reason, r.origin())
+
+		return true
+	}
+
+	p := o.preflight(r, d.Value)
+	if p.reason != ReasonAllowed {
+		o.denyPreflight(rw, r, statusOr(o.PreflightFailureStatus, http.StatusForbidden), p.reason, p.detail)
+
+		return true
+	}
+
+	o.setAllowOrigin(rw.Header(), d.Value)
+
+	header := p.header
+
+	if o.preflights != nil || o.PreserveExistingHeaders || o.Debug {
+		header = make(http.Header, len(p.header)+2)
+		for k, v := range p.header {
+			if _, ok := rw.Header()[k]; !ok || !o.PreserveExistingHeaders {
+				header[k] = v
+			}
 		}
+	}
 
-		if v := o.cache[HeaderMaxAge]; v != "" {
-			rw.Header().Set(HeaderMaxAge, v)
+	o.setDebug(header, r, ReasonAllowed)
+
+	if o.PassthroughPreflight {
+		for k, v := range header {
+			rw.Header()[k] = v
 		}
 
-		rw.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	o.respondPreflight(rw, r, header, statusOr(o.PreflightStatus, http.StatusNoContent))
+
+	return true
+}
+
+// serveActual writes the CORS headers of the actual request r, given the
+// decision d on its origin.
+func (o *Options) serveActual(rw http.ResponseWriter, r *Request, d Decision) {
+	if v := o.GetTimingAllowOrigin(r); v != "" {
+		o.setHeader(rw.Header(), HeaderTimingAllowOrigin, v)
+	}
+
+	if d.Reason != ReasonAllowed && d.Reason != ReasonNoOrigin {
+		o.setDebug(rw.Header(), r, d.Reason)
 
 		return
 	}
 
+	o.setAllowOrigin(rw.Header(), d.Value)
+
 	if v := o.cache[HeaderExposeHeaders]; v != "" {
-		rw.Header().Set(HeaderExposeHeaders, v)
+		o.setHeader(rw.Header(), HeaderExposeHeaders, v)
 	}
+
+	o.setDebug(rw.Header(), r, ReasonAllowed)
 }
+
+// setAllowOrigin sets Access-Control-Allow-Origin to origin, along with
+// Access-Control-Allow-Credentials. The latter only goes with an allowed
+// origin: on its own it means nothing to browsers, and only draws the
+// attention of security reviews.
+func (o *Options) setAllowOrigin(header http.Header, origin string) {
+	if origin == "" {
+		return
+	}
+
+	o.setHeader(header, HeaderAllowOrigin, origin)
+
+	if v := o.GetAllowCredentials(); v != "" {
+		o.setHeader(header, HeaderAllowCredentials, v)
+	}
+}
+
+// setHeader sets the response header name of header to value, unless
+// PreserveExistingHeaders keeps the value it already has.
+func (o *Options) setHeader(header http.Header, name, value string) {
+	if _, ok := header[name]; ok && o.PreserveExistingHeaders {
+		return
+	}
+
+	header.Set(name, value)
+}
+
+// contentLengthZero is the Content-Length of preflight responses. It is shared
+// by all of them: its capacity is its length, so appending to it copies it.
+var contentLengthZero = []string{"0"}
+
+// respondPreflight terminates a preflight request with header and status
+// through the PreflightResponder. Preflight responses have no body, which
+// header states explicitly for clients and proxies waiting for one, unless it
+// already holds a Content-Length.
+func (o *Options) respondPreflight(rw http.ResponseWriter, r *Request, header http.Header, status int) {
+	responder := o.PreflightResponder
+	if responder == nil {
+		responder = DefaultPreflightResponder
+	}
+
+	if _, ok := header[headerContentLength]; !ok {
+		header[headerContentLength] = contentLengthZero
+	}
+
+	responder.RespondPreflight(rw, r, header, status)
+}
+
+// Allocation and time budgets of the handler's hot paths, enforced by tests.
+// A change that makes these paths more expensive must update them.
+const (
+	allocBudgetNoOrigin  = 1
+	allocBudgetActual    = 4
+	allocBudgetPreflight = 8
+
+	// timeBudget is an order of magnitude guard, generous enough to hold on
+	// slow CI machines and with the race detector enabled.
+	timeBudget = 50 * time.Microsecond
+)
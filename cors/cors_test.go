@@ -1,10 +1,17 @@
 package cors_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/quintinheard/traefik-cors/cors"
 	"github.com/stretchr/testify/require"
@@ -19,6 +26,19 @@ func TestRequest_IsPreflight(t *testing.T) {
 	require.Equal(t, true, req.IsPreflight())
 }
 
+func TestRequest_IsPreflight_WithoutRequestHeaders(t *testing.T) {
+	req := (*cors.Request)(httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil))
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodDelete)
+
+	require.Equal(t, true, req.IsPreflight())
+
+	req.Header.Del(cors.HeaderRequestMethod)
+	req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+	require.Equal(t, false, req.IsPreflight())
+}
+
 func TestRequest_IsNotPreflight(t *testing.T) {
 	req := (*cors.Request)(httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil))
 	req.Header.Set(cors.HeaderOrigin, "https://example.com")
@@ -49,3 +69,2931 @@ func TestHandler_ServeHTTP(t *testing.T) {
 	require.Equal(t, "GET, POST", res.Header.Get(cors.HeaderAllowMethods))
 	require.Nil(t, res.Body.Close())
 }
+
+func TestOptions_GetAllowOrigin_AllowLocalhost(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowLocalhost = true
+
+	tests := map[string]string{
+		"http://localhost:3000":     "http://localhost:3000",
+		"https://localhost":         "https://localhost",
+		"http://app.localhost:8080": "http://app.localhost:8080",
+		"http://127.0.0.1:5173":     "http://127.0.0.1:5173",
+		"http://[::1]:4200":         "http://[::1]:4200",
+		"https://example.com":       "https://example.com",
+		"http://localhost.evil.com": "",
+		"http://192.168.1.10:3000":  "",
+		"http://localhost/path":     "",
+	}
+
+	for origin, expected := range tests {
+		req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		require.Equal(t, expected, o.GetAllowOrigin(req), origin)
+	}
+
+	require.Equal(t, cors.HeaderOrigin, o.GetVary())
+}
+
+func TestOptions_GetAllowOrigin_LocalhostDisabled(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+
+	req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+	req.Header.Set(cors.HeaderOrigin, "http://localhost:3000")
+
+	require.Equal(t, "", o.GetAllowOrigin(req))
+}
+
+func TestOptions_GetAllowOrigin_AllowOriginCIDRs(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOriginCIDRs = []string{"10.20.0.0/16", "fd00::/8"}
+
+	tests := map[string]string{
+		"http://10.20.30.40:8080": "http://10.20.30.40:8080",
+		"https://10.20.1.1":       "https://10.20.1.1",
+		"http://[fd00::1]:8080":   "http://[fd00::1]:8080",
+		"http://10.21.0.1":        "",
+		"http://example.com":      "",
+		"http://10.20.30.40.nip":  "",
+	}
+
+	check := func() {
+		for origin, expected := range tests {
+			req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+			req.Header.Set(cors.HeaderOrigin, origin)
+
+			require.Equal(t, expected, o.GetAllowOrigin(req), origin)
+		}
+	}
+
+	// before and after the CIDR blocks are parsed by NewHandler
+	check()
+	o.NewHandler()
+	check()
+
+	require.Equal(t, cors.HeaderOrigin, o.GetVary())
+}
+
+func TestOptions_Validate(t *testing.T) {
+	o := cors.NewOptions()
+	require.Nil(t, o.Validate())
+
+	o.AllowOriginCIDRs = []string{"10.0.0.0/8", "10.0.0.0/33"}
+	require.NotNil(t, o.Validate())
+
+	o.AllowOriginCIDRs = []string{"not-a-cidr"}
+	require.NotNil(t, o.Validate())
+}
+
+func TestHeaderPreset_ReturnsCopy(t *testing.T) {
+	p, ok := cors.HeaderPreset(cors.PresetPagination)
+	require.True(t, ok)
+
+	p[0] = "Changed"
+
+	p, _ = cors.HeaderPreset(cors.PresetPagination)
+	require.Equal(t, "Link", p[0])
+
+	_, ok = cors.HeaderPreset("unknown")
+	require.False(t, ok)
+}
+
+func TestOptions_GetAllowOrigin_LargeList(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://a.example.com", "https://b.example.com"}
+	o.NewHandler()
+
+	req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+	req.Header.Set(cors.HeaderOrigin, "https://b.example.com")
+	require.Equal(t, "https://b.example.com", o.GetAllowOrigin(req))
+
+	req.Header.Set(cors.HeaderOrigin, "https://c.example.com")
+	require.Equal(t, "", o.GetAllowOrigin(req))
+
+	o.AllowOrigins = append(o.AllowOrigins, cors.HeaderValueWildcard)
+	o.NewHandler()
+	require.Equal(t, cors.HeaderValueWildcard, o.GetAllowOrigin(req))
+}
+
+func tenantOrigins(n int) []string {
+	origins := make([]string, n)
+	for i := range origins {
+		origins[i] = fmt.Sprintf("https://tenant-%d.example.com", i)
+	}
+
+	return origins
+}
+
+func benchmarkGetAllowOrigin(b *testing.B, origin string, build bool) {
+	b.Helper()
+
+	o := cors.NewOptions()
+	o.AllowOrigins = tenantOrigins(40000)
+
+	if build {
+		o.NewHandler()
+	}
+
+	req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+	req.Header.Set(cors.HeaderOrigin, origin)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		o.GetAllowOrigin(req)
+	}
+}
+
+func BenchmarkOptions_GetAllowOrigin_ScanHit(b *testing.B) {
+	benchmarkGetAllowOrigin(b, "https://tenant-39999.example.com", false)
+}
+
+func BenchmarkOptions_GetAllowOrigin_ScanMiss(b *testing.B) {
+	benchmarkGetAllowOrigin(b, "https://unknown.example.com", false)
+}
+
+func BenchmarkOptions_GetAllowOrigin_MapHit(b *testing.B) {
+	benchmarkGetAllowOrigin(b, "https://tenant-39999.example.com", true)
+}
+
+func BenchmarkOptions_GetAllowOrigin_MapMiss(b *testing.B) {
+	benchmarkGetAllowOrigin(b, "https://unknown.example.com", true)
+}
+
+func TestHandler_ServeHTTP_SuppressSameOriginHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		origin   string
+		proto    string
+		suppress bool
+		expected string
+	}{
+		{"https default port", "https://api.example.com:443/", "https://api.example.com", "", true, ""},
+		{"http default port", "http://api.example.com:80/", "http://api.example.com", "", true, ""},
+		{"forwarded proto", "http://api.example.com/", "https://api.example.com", "https", true, ""},
+		{"scheme mismatch", "http://api.example.com/", "https://api.example.com", "", true, "https://api.example.com"},
+		{"cross origin", "https://api.example.com/", "https://app.example.com", "", true, "https://app.example.com"},
+		{"off by default", "https://api.example.com/", "https://api.example.com", "", false, "https://api.example.com"},
+	}
+
+	for _, tt := range tests {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"https://api.example.com", "http://api.example.com", "https://app.example.com"}
+		o.AllowCredentials = true
+		o.SuppressSameOriginHeaders = tt.suppress
+		o.TrustedProxies = []string{"192.0.2.0/24"}
+
+		req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+		req.Header.Set(cors.HeaderOrigin, tt.origin)
+
+		if tt.proto != "" {
+			req.Header.Set(cors.HeaderForwardedProto, tt.proto)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		res := rec.Result()
+		require.Equal(t, tt.expected, res.Header.Get(cors.HeaderAllowOrigin), tt.name)
+
+		if tt.expected == "" {
+			require.Equal(t, "", res.Header.Get(cors.HeaderAllowCredentials), tt.name)
+			require.Equal(t, "", res.Header.Get(cors.HeaderVary), tt.name)
+		}
+
+		require.Nil(t, res.Body.Close())
+	}
+}
+
+func TestHandler_ServeHTTP_DefaultPreflightResponder(t *testing.T) {
+	serve := func(responder cors.PreflightResponder) *httptest.ResponseRecorder {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+		o.AllowHeaders = []string{"Content-Type"}
+		o.AllowMethods = []string{http.MethodGet, http.MethodPut}
+		o.AllowCredentials = true
+		o.PreflightResponder = responder
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	implicit := serve(nil)
+	explicit := serve(cors.DefaultPreflightResponder)
+
+	require.Equal(t, http.StatusNoContent, implicit.Code)
+	require.Equal(t, implicit.Code, explicit.Code)
+	require.Equal(t, implicit.Header(), explicit.Header())
+	require.Equal(t, implicit.Body.Bytes(), explicit.Body.Bytes())
+	require.Equal(t, http.Header{
+		cors.HeaderVary:             {"Origin"},
+		cors.HeaderAllowOrigin:      {"https://example.com"},
+		cors.HeaderAllowCredentials: {"true"},
+		cors.HeaderAllowMethods:     {"GET, PUT"},
+		cors.HeaderAllowHeaders:     {"Content-Type"},
+		cors.HeaderMaxAge:           {"5"},
+		"Content-Length":            {"0"},
+	}, implicit.Header())
+}
+
+func TestOptions_GetVary(t *testing.T) {
+	tests := []struct {
+		origins     []string
+		credentials bool
+		expected    string
+	}{
+		{nil, false, ""},
+		{nil, true, ""},
+		{[]string{"*"}, false, ""},
+		{[]string{"*"}, true, cors.HeaderOrigin},
+		{[]string{"https://example.com"}, false, cors.HeaderOrigin},
+		{[]string{"https://example.com"}, true, cors.HeaderOrigin},
+		{[]string{"https://*.example.com"}, false, cors.HeaderOrigin},
+		{[]string{cors.OriginSelf}, false, cors.HeaderOrigin},
+		{[]string{"https://example.com", "https://example.org"}, false, cors.HeaderOrigin},
+		{[]string{"*", "https://example.com"}, false, cors.HeaderOrigin},
+	}
+
+	for _, test := range tests {
+		o := cors.NewOptions()
+		o.AllowOrigins = test.origins
+		o.AllowCredentials = test.credentials
+		require.Equal(t, test.expected, o.GetVary(), "%v, credentials %t", test.origins, test.credentials)
+	}
+
+	o := cors.NewOptions()
+	o.AllowLocalhost = true
+	require.Equal(t, cors.HeaderOrigin, o.GetVary())
+
+	o = cors.NewOptions()
+	o.TimingAllowOrigins = []string{"https://example.com"}
+	require.Equal(t, cors.HeaderOrigin, o.GetVary())
+
+	o.TimingAllowOrigins = []string{"https://example.com", "*"}
+	require.Empty(t, o.GetVary())
+}
+
+// TestHandler_ServeHTTP_VarySingleOrigin checks that a shared cache keying on
+// the Vary header cannot serve the response for one Origin to another.
+func TestHandler_ServeHTTP_VarySingleOrigin(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodPut}
+
+	h := o.NewHandler()
+
+	serve := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+		}
+
+		if method == http.MethodOptions {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		for _, origin := range []string{"", "https://example.com", "https://evil.example.com"} {
+			rec := serve(method, origin)
+			require.Equal(t, []string{cors.HeaderOrigin}, rec.Header().Values(cors.HeaderVary), "%s from %q", method, origin)
+		}
+
+		require.Equal(t, "https://example.com", serve(method, "https://example.com").Header().Get(cors.HeaderAllowOrigin), method)
+		require.Empty(t, serve(method, "").Header().Get(cors.HeaderAllowOrigin), method)
+		require.Empty(t, serve(method, "https://evil.example.com").Header().Get(cors.HeaderAllowOrigin), method)
+	}
+}
+
+func TestOptions_GetPreflightVary(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.NewHandler()
+	require.Equal(t, "Origin", o.GetPreflightVary())
+
+	o.EnforceMethods = true
+	require.Equal(t, "Origin, Access-Control-Request-Method", o.GetPreflightVary())
+
+	o.EnforceHeaders = true
+	require.Equal(t, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", o.GetPreflightVary())
+
+	o.AllowOrigins = []string{"*"}
+	o.EnforceMethods = false
+	require.Equal(t, "Access-Control-Request-Headers", o.GetPreflightVary())
+
+	o.EnforceHeaders = false
+	require.Equal(t, "", o.GetPreflightVary())
+
+	o.ReflectRequestMethods = true
+	o.ReflectRequestHeaders = true
+	o.NewHandler()
+	require.Equal(t, "Access-Control-Request-Method, Access-Control-Request-Headers", o.GetPreflightVary())
+}
+
+func TestHandler_ServeHTTP_VaryMerged(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.EnforceHeaders = true
+
+	h := o.NewHandler()
+
+	serve := func(preflight bool, vary ...string) []string {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		if preflight {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		}
+
+		rec := httptest.NewRecorder()
+		rec.Header()[cors.HeaderVary] = vary
+		h.ServeHTTP(rec, req)
+
+		return rec.Header().Values(cors.HeaderVary)
+	}
+
+	tests := []struct {
+		preflight bool
+		vary      []string
+		expected  []string
+	}{
+		{false, nil, []string{"Origin"}},
+		{true, nil, []string{"Origin, Access-Control-Request-Headers"}},
+		{false, []string{"origin"}, []string{"origin"}},
+		{false, []string{"Accept-Encoding"}, []string{"Accept-Encoding, Origin"}},
+		{true, []string{"Accept-Encoding", "ORIGIN,access-control-request-method"}, []string{"Accept-Encoding, ORIGIN, access-control-request-method, Access-Control-Request-Headers"}},
+		{true, []string{"*"}, []string{"*"}},
+		{false, []string{"Accept, *"}, []string{"Accept, *"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, serve(test.preflight, test.vary...), "%t %v", test.preflight, test.vary)
+	}
+}
+
+func TestOptions_GetAllowOrigin_WildcardWithCredentials(t *testing.T) {
+	for _, build := range []bool{false, true} {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{cors.HeaderValueWildcard}
+
+		if build {
+			o.NewHandler()
+		}
+
+		req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		require.Equal(t, cors.HeaderValueWildcard, o.GetAllowOrigin(req))
+		require.Equal(t, "", o.GetVary())
+
+		o.AllowCredentials = true
+		require.Equal(t, "https://example.com", o.GetAllowOrigin(req))
+		require.Equal(t, cors.HeaderOrigin, o.GetVary())
+
+		req.Header.Del(cors.HeaderOrigin)
+		require.Equal(t, cors.HeaderValueWildcard, o.GetAllowOrigin(req))
+	}
+}
+
+func TestOptions_GetAllowOrigin_OriginMatchers(t *testing.T) {
+	re, err := cors.NewRegexpMatcher(`https://[a-z0-9-]+\.preview\.example\.com`)
+	require.Nil(t, err)
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.OriginMatchers = []cors.OriginMatcher{
+		re,
+		cors.OriginMatcherFunc(func(origin string) bool {
+			return origin == "https://tenant.example.net"
+		}),
+	}
+	o.NewHandler()
+
+	tests := map[string]string{
+		"https://example.com":                       "https://example.com",
+		"https://pr-42.preview.example.com":         "https://pr-42.preview.example.com",
+		"https://tenant.example.net":                "https://tenant.example.net",
+		"https://pr-42.preview.example.com.evil.io": "",
+		"https://evil.io":                           "",
+	}
+
+	for origin, expected := range tests {
+		req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		require.Equal(t, expected, o.GetAllowOrigin(req), origin)
+	}
+
+	require.Equal(t, cors.HeaderOrigin, o.GetVary())
+
+	_, err = cors.NewRegexpMatcher("(")
+	require.NotNil(t, err)
+}
+
+func TestMiddleware_StrictMode(t *testing.T) {
+	panicking := cors.OriginMatcherFunc(func(string) bool {
+		panic("backend unavailable")
+	})
+
+	tests := []struct {
+		name       string
+		strict     bool
+		status     int
+		expected   int
+		nextCalled bool
+	}{
+		{"non-strict degrades to no headers", false, 0, http.StatusOK, true},
+		{"strict default status", true, 0, http.StatusInternalServerError, false},
+		{"strict configured status", true, http.StatusServiceUnavailable, http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"https://example.com"}
+		o.OriginMatchers = []cors.OriginMatcher{panicking}
+		o.StrictMode = tt.strict
+		o.StrictModeStatus = tt.status
+
+		called := false
+		next := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			called = true
+
+			rw.WriteHeader(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://other.example.com")
+
+		rec := httptest.NewRecorder()
+		o.NewMiddleware(next).ServeHTTP(rec, req)
+
+		require.Equal(t, tt.expected, rec.Code, tt.name)
+		require.Equal(t, tt.nextCalled, called, tt.name)
+		require.Equal(t, "", rec.Header().Get(cors.HeaderAllowOrigin), tt.name)
+	}
+}
+
+func TestMiddleware_StrictModeLogged(t *testing.T) {
+	var buf bytes.Buffer
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.OriginMatchers = []cors.OriginMatcher{cors.OriginMatcherFunc(func(string) bool {
+		panic("backend unavailable")
+	})}
+	o.StrictMode = true
+	o.ErrorLog = log.New(&buf, "cors: ", 0)
+
+	h := o.NewMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	require.Equal(t, cors.Stats{}, o.Stats())
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://other.example.com")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.Equal(t, cors.Stats{StrictModeRejections: 2}, o.Stats())
+	require.Equal(t, strings.Repeat(`cors: strict mode: GET /api/ from "https://other.example.com": origin matcher panicked: backend unavailable`+"\n", 2), buf.String())
+
+	// Errors are neither logged nor counted without StrictMode.
+	buf.Reset()
+	o.StrictMode = false
+	h = o.NewMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://other.example.com")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, cors.Stats{}, o.Stats())
+	require.Empty(t, buf.String())
+}
+
+func TestMiddleware_ServeHTTP(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodGet}
+
+	called := 0
+	h := o.NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		called++
+
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+	req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, 0, called)
+
+	req = httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, called)
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+}
+
+func TestHandler_ServeHTTP_HeaderListSeparator(t *testing.T) {
+	for sep, expected := range map[string][4]string{
+		"":                        {"GET, PUT", "Content-Type, X-Request-Id", "Location, Link", "GET, PUT, OPTIONS"},
+		cors.ListSeparator:        {"GET, PUT", "Content-Type, X-Request-Id", "Location, Link", "GET, PUT, OPTIONS"},
+		cors.ListSeparatorCompact: {"GET,PUT", "Content-Type,X-Request-Id", "Location,Link", "GET,PUT,OPTIONS"},
+	} {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"*"}
+		o.AllowMethods = []string{http.MethodGet, http.MethodPut}
+		o.AllowHeaders = []string{"Content-Type", "X-Request-Id"}
+		o.ExposeHeaders = []string{"Location", "Link"}
+		o.HeaderListSeparator = sep
+		require.Nil(t, o.Validate())
+
+		h := o.NewHandler()
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		req.Header.Set(cors.HeaderRequestHeaders, "content-type")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, expected[0], rec.Header().Get(cors.HeaderAllowMethods))
+		require.Equal(t, expected[1], rec.Header().Get(cors.HeaderAllowHeaders))
+
+		req = httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, expected[2], rec.Header().Get(cors.HeaderExposeHeaders))
+
+		// OPTIONS requests that are not preflights get the Allow header.
+		o.OptionsPassthrough = false
+		req = httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+
+		rec = httptest.NewRecorder()
+		o.NewMiddleware(http.NotFoundHandler()).ServeHTTP(rec, req)
+		require.Equal(t, expected[3], rec.Header().Get(cors.HeaderAllow))
+	}
+
+	o := cors.NewOptions()
+	o.HeaderListSeparator = ";"
+	require.NotNil(t, o.Validate())
+}
+
+func TestRequest_EmptyOriginIsAbsent(t *testing.T) {
+	for name, set := range map[string]func(http.Header){
+		"absent":          func(http.Header) {},
+		"empty":           func(h http.Header) { h[cors.HeaderOrigin] = []string{""} },
+		"whitespace only": func(h http.Header) { h[cors.HeaderOrigin] = []string{" \t "} },
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		set(req.Header)
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+		require.False(t, (*cors.Request)(req).IsPreflight(), name)
+
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{cors.HeaderValueWildcard}
+		o.AllowCredentials = true
+		o.SuppressSameOriginHeaders = true
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, name)
+		require.Equal(t, "", rec.Header().Get(cors.HeaderAllowOrigin), name)
+		require.Equal(t, "", rec.Header().Get(cors.HeaderAllowCredentials), name)
+		require.Equal(t, "", rec.Header().Get(cors.HeaderAllowMethods), name)
+
+		o.AllowOrigins = []string{"https://example.com", cors.OriginSelf}
+
+		rec = httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+		require.Equal(t, "", rec.Header().Get(cors.HeaderAllowOrigin), name)
+	}
+}
+
+func TestMiddleware_NoOrigin(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{cors.HeaderValueWildcard}
+	o.AllowMethods = []string{http.MethodPut}
+	o.AllowHeaders = []string{"X-Requested-With"}
+	o.ExposeHeaders = []string{"X-Request-Id"}
+	o.AllowCredentials = true
+
+	var called int
+
+	h := o.NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		called++
+
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for i, method := range []string{http.MethodGet, http.MethodPost, http.MethodOptions} {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		req.Header.Set(cors.HeaderRequestHeaders, "X-Requested-With")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Equal(t, i+1, called, method)
+		require.Equal(t, http.StatusOK, rec.Code, method)
+		require.Equal(t, http.Header{cors.HeaderVary: {cors.HeaderOrigin}}, rec.Header(), method)
+	}
+}
+
+func TestHandler_ServeHTTP_RequireSecureOrigins(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"*"}
+	o.AllowMethods = []string{http.MethodGet}
+	o.ExposeHeaders = []string{"X-Total-Count"}
+	o.RequireSecureOrigins = true
+	h := o.NewHandler()
+
+	tests := map[string]bool{
+		"https://example.com":   true,
+		"http://example.com":    false,
+		"ws://example.com":      false,
+		"null":                  false,
+		"http://localhost:3000": true,
+		"http://127.0.0.1:8080": true,
+		"http://[::1]:4200":     true,
+	}
+
+	for origin, allowed := range tests {
+		preflight := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		preflight.Header.Set(cors.HeaderOrigin, origin)
+		preflight.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		preflight.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, preflight)
+
+		require.Equal(t, http.StatusNoContent, rec.Code, origin)
+		require.Equal(t, allowed, rec.Header().Get(cors.HeaderAllowOrigin) != "", origin)
+		require.Equal(t, allowed, rec.Header().Get(cors.HeaderAllowMethods) != "", origin)
+		require.Equal(t, allowed, rec.Header().Get(cors.HeaderMaxAge) != "", origin)
+
+		actual := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		actual.Header.Set(cors.HeaderOrigin, origin)
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, actual)
+
+		require.Equal(t, allowed, rec.Header().Get(cors.HeaderAllowOrigin) != "", origin)
+		require.Equal(t, allowed, rec.Header().Get(cors.HeaderExposeHeaders) != "", origin)
+	}
+}
+
+func TestHandler_ServeHTTP_RequireSecureOriginsWithCredentials(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"http://example.com", "https://example.com"}
+	o.AllowCredentials = true
+	o.RequireSecureOrigins = true
+	h := o.NewHandler()
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "http://example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowCredentials))
+	require.Equal(t, cors.HeaderOrigin, rec.Header().Get(cors.HeaderVary))
+	require.Empty(t, o.GetAllowOrigin((*cors.Request)(req)))
+}
+
+func TestHandler_ServeHTTP_RefusesUnsafeOrigin(t *testing.T) {
+	hostile := []string{
+		"https://example.com\r\nSet-Cookie: a=b",
+		"https://example.com\nX-Injected: 1",
+		"https://exa\x00mple.com",
+		"https://example.com\x7f",
+		"https://example.com, https://evil.com",
+		"https://example.com\t",
+		" https://example.com",
+		"https://exa mple.com",
+	}
+
+	allowAll := cors.NewOptions()
+	allowAll.OriginMatchers = []cors.OriginMatcher{
+		cors.OriginMatcherFunc(func(string) bool { return true }),
+	}
+
+	echoWildcard := cors.NewOptions()
+	echoWildcard.AllowOrigins = []string{"*"}
+	echoWildcard.AllowCredentials = true
+
+	for name, o := range map[string]*cors.Options{"matcher": allowAll, "wildcard": echoWildcard} {
+		h := o.NewHandler()
+
+		for _, origin := range hostile {
+			req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+			req.Header[cors.HeaderOrigin] = []string{origin}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin), "%s: %q", name, origin)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin), name)
+	}
+}
+
+func TestMiddleware_MaxOriginLength(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"*"}
+	o.MaxOriginLength = 32
+
+	var forwarded int
+
+	h := o.NewMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ }))
+
+	require.Equal(t, cors.Stats{}, o.Stats())
+
+	long := "https://" + strings.Repeat("a", 21) + ".com"
+	require.Len(t, long, 33)
+
+	preflight := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	preflight.Header.Set(cors.HeaderOrigin, long)
+	preflight.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+	preflight.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, preflight)
+
+	require.Equal(t, 1, forwarded)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowMethods))
+	require.Empty(t, o.GetAllowOrigin((*cors.Request)(preflight)))
+
+	actual := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	actual.Header.Set(cors.HeaderOrigin, long[:32])
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, actual)
+
+	require.Equal(t, 2, forwarded)
+	require.Equal(t, "*", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, cors.Stats{OversizedOrigins: 1}, o.Stats())
+}
+
+func TestOptions_Decide(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.MaxOriginLength = 40
+	o.OriginMatchers = []cors.OriginMatcher{
+		cors.OriginMatcherFunc(func(origin string) bool {
+			if origin == "https://panic.example.com" {
+				panic("boom")
+			}
+
+			return origin == "https://bad\x01.example.com"
+		}),
+	}
+	o.NewHandler()
+
+	tests := map[string]cors.Decision{
+		"":                                   {Reason: cors.ReasonNoOrigin},
+		"https://example.com":                {Value: "https://example.com", Allowed: true, Reason: cors.ReasonAllowed},
+		"https://other.example.com":          {Reason: cors.ReasonNotAllowed},
+		"https://" + strings.Repeat("a", 40): {Reason: cors.ReasonOversizedOrigin},
+		"https://bad\x01.example.com":        {Reason: cors.ReasonUnsafeOrigin},
+		"https://panic.example.com":          {Reason: cors.ReasonMatcherPanic},
+	}
+
+	for origin, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header[cors.HeaderOrigin] = []string{origin}
+
+		require.Equal(t, expected, o.Decide((*cors.Request)(req)), "%q", origin)
+
+		value, allowed := o.AllowOrigin((*cors.Request)(req))
+		require.Equal(t, expected.Value, value, "%q", origin)
+		require.Equal(t, expected.Allowed, allowed, "%q", origin)
+	}
+
+	o.RequireSecureOrigins = true
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "http://example.com")
+
+	require.Equal(t, cors.Decision{Reason: cors.ReasonInsecureOrigin}, o.Decide((*cors.Request)(req)))
+}
+
+func TestOptions_AllowOrigin_WildcardWithoutOrigin(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{cors.HeaderValueWildcard}
+
+	req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+
+	value, allowed := o.AllowOrigin(req)
+	require.Equal(t, cors.HeaderValueWildcard, value)
+	require.False(t, allowed)
+	require.Equal(t, cors.ReasonNoOrigin, o.Decide(req).Reason)
+	require.Equal(t, o.GetAllowOrigin(req), value)
+
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+	value, allowed = o.AllowOrigin(req)
+	require.Equal(t, cors.HeaderValueWildcard, value)
+	require.True(t, allowed)
+}
+
+func TestOptions_GetAllowOrigin_SelfBehindTrustedProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		remote   string
+		header   http.Header
+		expected bool
+	}{
+		{"no forwarded headers", "192.0.2.1:1234", http.Header{}, false},
+		{"x-forwarded", "192.0.2.1:1234", http.Header{
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"api.example.com"},
+		}, true},
+		{"forwarded", "[2001:db8::1]:1234", http.Header{
+			"Forwarded": {`for=198.51.100.7;proto=https;host="api.example.com", for=192.0.2.1`},
+		}, true},
+		{"forwarded takes precedence", "192.0.2.1:1234", http.Header{
+			"Forwarded":         {"proto=http;host=api.example.com"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"api.example.com"},
+		}, false},
+		{"untrusted client", "198.51.100.7:1234", http.Header{
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"api.example.com"},
+		}, false},
+	}
+
+	for _, tt := range tests {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{cors.OriginSelf}
+		o.TrustedProxies = []string{"192.0.2.1", "2001:db8::/32"}
+		require.Nil(t, o.Validate(), tt.name)
+		o.NewHandler()
+
+		req := httptest.NewRequest(http.MethodGet, "http://backend.internal:8080/api/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header = tt.header
+		req.Header.Set(cors.HeaderOrigin, "https://api.example.com")
+
+		require.Equal(t, tt.expected, o.GetAllowOrigin((*cors.Request)(req)) != "", tt.name)
+	}
+
+	o := cors.NewOptions()
+	o.TrustedProxies = []string{"not-an-ip"}
+	require.NotNil(t, o.Validate())
+}
+
+func TestHandler_ServeHTTP_DeniedOriginCache(t *testing.T) {
+	var calls int
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://*.example.com"}
+	o.AllowCredentials = true
+	o.OriginMatchers = []cors.OriginMatcher{
+		cors.OriginMatcherFunc(func(string) bool {
+			calls++
+
+			return false
+		}),
+	}
+	h := o.NewHandler()
+
+	serve := func() http.Header {
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://evil.com")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	fresh := serve()
+	require.Equal(t, 1, calls)
+	require.Equal(t, fresh, serve())
+	require.Equal(t, 1, calls)
+
+	h = o.NewHandler()
+	require.Equal(t, fresh, serve())
+	require.Equal(t, 2, calls)
+
+	o.DeniedOriginCacheTTL = time.Millisecond
+	h = o.NewHandler()
+	serve()
+	time.Sleep(5 * time.Millisecond)
+	serve()
+	require.Equal(t, 4, calls)
+
+	o.DeniedOriginCacheSize = 0
+	h = o.NewHandler()
+	serve()
+	serve()
+	require.Equal(t, 6, calls)
+}
+
+// countingReader is an endless body counting the bytes read from it.
+type countingReader struct{ n int }
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.n += len(p)
+
+	return len(p), nil
+}
+
+func TestOptions_GetExposeHeaders_CredentialFallback(t *testing.T) {
+	tests := []struct {
+		expose      []string
+		fallback    []string
+		credentials bool
+		expected    string
+	}{
+		{nil, nil, true, ""},
+		{[]string{"X-A", "X-B"}, []string{"X-C"}, true, "X-A, X-B"},
+		{[]string{"*"}, nil, false, "*"},
+		{[]string{"*", "X-A"}, []string{"X-B"}, false, "*"},
+		{[]string{"*"}, nil, true, "*"},
+		{[]string{"*"}, []string{"X-Request-Id", "Link"}, true, "*, X-Request-Id, Link"},
+		{[]string{"X-A", " * "}, []string{"x-a", "", "*", "X-B"}, true, "*, X-A, X-B"},
+	}
+
+	for _, test := range tests {
+		o := cors.NewOptions()
+		o.ExposeHeaders = test.expose
+		o.ExposeHeadersCredentialFallback = test.fallback
+		o.AllowCredentials = test.credentials
+		require.Equal(t, test.expected, o.GetExposeHeaders(), "%v %v, credentials %t", test.expose, test.fallback, test.credentials)
+	}
+}
+
+func TestOptions_CanonicalHeaders(t *testing.T) {
+	tests := []struct {
+		headers  []string
+		expected string
+	}{
+		{[]string{"content-type", "X-REQUEST-ID", "x-api-key"}, "Content-Type, X-Request-Id, X-Api-Key"},
+		{[]string{" etag ", "ETag", "Etag", ""}, "Etag"},
+		{[]string{"x-custom.v2", "x-a--b", "-x-leading"}, "X-Custom.v2, X-A--B, -X-Leading"},
+		{[]string{"x-b", "not a header", "x-b"}, "X-B, not a header"},
+	}
+
+	for _, test := range tests {
+		o := cors.NewOptions()
+		o.AllowHeaders = test.headers
+		o.ExposeHeaders = test.headers
+		require.Equal(t, test.expected, o.GetAllowHeaders(), "%q", test.headers)
+		require.Equal(t, test.expected, o.GetExposeHeaders(), "%q", test.headers)
+	}
+
+	// Echoed headers are spelled canonically too, and matched whatever their
+	// configured casing.
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"X-REQUEST-ID", "x-tag"}
+	o.PartialAllowHeaders = true
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+	req.Header.Set(cors.HeaderRequestHeaders, "x-tag, x-request-id, x-other")
+
+	rec := httptest.NewRecorder()
+	o.NewHandler().ServeHTTP(rec, req)
+	require.Equal(t, "X-Tag, X-Request-Id", rec.Header().Get(cors.HeaderAllowHeaders))
+}
+
+func TestOptions_NewHandler_CleansLists(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{" https://example.com", "", "https://example.com\t", " self "}
+	o.AllowMethods = []string{" GET", "POST ", "", "GET"}
+	o.AllowHeaders = []string{" X-A", "", "x-a ", "\t"}
+	o.ExposeHeaders = []string{"", " X-B ", "X-B"}
+
+	origins := append([]string(nil), o.AllowOrigins...)
+	methods := append([]string(nil), o.AllowMethods...)
+	headers := append([]string(nil), o.AllowHeaders...)
+	exposed := append([]string(nil), o.ExposeHeaders...)
+
+	require.NoError(t, o.Validate())
+	require.Equal(t, []string{`allowed origin "https://example.com" duplicates "https://example.com"`}, o.Warnings())
+
+	h := o.NewHandler()
+
+	serve := func(method string) http.Header {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		if method == http.MethodOptions {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	header := serve(http.MethodOptions)
+	require.Equal(t, "https://example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "GET, POST", header.Get(cors.HeaderAllowMethods))
+	require.Equal(t, "X-A", header.Get(cors.HeaderAllowHeaders))
+
+	header = serve(http.MethodGet)
+	require.Equal(t, "https://example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "X-B", header.Get(cors.HeaderExposeHeaders))
+
+	// The configured lists are left untouched.
+	require.Equal(t, origins, o.AllowOrigins)
+	require.Equal(t, methods, o.AllowMethods)
+	require.Equal(t, headers, o.AllowHeaders)
+	require.Equal(t, exposed, o.ExposeHeaders)
+
+	o = cors.NewOptions()
+	o.AllowOrigins = []string{" * ", ""}
+	require.Empty(t, o.GetVary())
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.org")
+	require.Equal(t, "*", o.GetAllowOrigin((*cors.Request)(req)))
+
+	o.NewHandler()
+	require.Equal(t, "*", o.GetAllowOrigin((*cors.Request)(req)))
+}
+
+func TestHandler_ServeHTTP_AutoReflect(t *testing.T) {
+	type result struct {
+		status        int
+		origin        string
+		methods       string
+		headers       string
+		exposeHeaders string
+	}
+
+	tests := []struct {
+		credentials bool
+		autoReflect bool
+		expected    result
+	}{
+		{false, false, result{http.StatusNoContent, "*", "*", "*", "*"}},
+		{false, true, result{http.StatusNoContent, "*", "*", "*", "*"}},
+		{true, false, result{http.StatusNoContent, "https://example.com", "PUT", "x-a", "*, X-Request-Id"}},
+		{true, true, result{http.StatusNoContent, "https://example.com", "PUT", "x-a", "*, X-Request-Id"}},
+	}
+
+	for _, test := range tests {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"*"}
+		o.AllowMethods = []string{"*"}
+		o.AllowHeaders = []string{"*"}
+		o.ExposeHeaders = []string{"*"}
+		o.ExposeHeadersCredentialFallback = []string{"X-Request-Id"}
+		o.AllowCredentials = test.credentials
+		o.AutoReflect = test.autoReflect
+		require.NoError(t, o.Validate())
+
+		h := o.NewHandler()
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		req.Header.Set(cors.HeaderRequestHeaders, "X-A")
+
+		preflight := httptest.NewRecorder()
+		h.ServeHTTP(preflight, req)
+
+		req = httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		actual := httptest.NewRecorder()
+		h.ServeHTTP(actual, req)
+
+		require.Equal(t, test.expected, result{
+			status:        preflight.Code,
+			origin:        actual.Header().Get(cors.HeaderAllowOrigin),
+			methods:       preflight.Header().Get(cors.HeaderAllowMethods),
+			headers:       preflight.Header().Get(cors.HeaderAllowHeaders),
+			exposeHeaders: actual.Header().Get(cors.HeaderExposeHeaders),
+		}, "credentials %t, auto reflect %t", test.credentials, test.autoReflect)
+	}
+
+	// The wildcard ExposeHeaders needs an explicit list to fall back to.
+	o := cors.NewOptions()
+	o.ExposeHeaders = []string{"*"}
+	o.AllowCredentials = true
+	o.AutoReflect = true
+	require.Error(t, o.Validate())
+
+	o.AllowCredentials = false
+	require.NoError(t, o.Validate())
+}
+
+func TestOptions_GetMaxAge(t *testing.T) {
+	for maxAge, expected := range map[int]string{
+		-1:   "",
+		-600: "",
+		0:    "0",
+		5:    "5",
+		7200: "7200",
+	} {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"https://example.com"}
+		o.MaxAge = maxAge
+		require.Equal(t, expected, o.GetMaxAge(), maxAge)
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+		require.Equal(t, expected, rec.Header().Get(cors.HeaderMaxAge), maxAge)
+
+		_, ok := rec.Header()[cors.HeaderMaxAge]
+		require.Equal(t, expected != "", ok, maxAge)
+	}
+}
+
+func TestOptions_GetMaxAge_Duration(t *testing.T) {
+	for duration, expected := range map[time.Duration]string{
+		0:                       "5",
+		10 * time.Minute:        "600",
+		1500 * time.Millisecond: "1",
+		500 * time.Millisecond:  "0",
+		-time.Second:            "",
+		48 * time.Hour:          "86400",
+	} {
+		o := cors.NewOptions()
+		o.MaxAgeDuration = duration
+		require.Equal(t, expected, o.GetMaxAge(), duration)
+	}
+}
+
+func TestOptions_GetMaxAge_Ceiling(t *testing.T) {
+	o := cors.NewOptions()
+	o.MaxAge = 604800
+	require.Equal(t, "86400", o.GetMaxAge())
+	require.Equal(t, []string{"max age 604800 exceeds the ceiling browsers apply and is clamped to 86400"}, o.Warnings())
+
+	o.MaxAgeCeiling = 7200
+	require.Equal(t, "7200", o.GetMaxAge())
+
+	o.MaxAge = 7200
+	require.Equal(t, "7200", o.GetMaxAge())
+	require.Empty(t, o.Warnings())
+
+	o.MaxAge = 604800
+	o.MaxAgeCeiling = 0
+	require.Equal(t, "604800", o.GetMaxAge())
+	require.Empty(t, o.Warnings())
+
+	o.MaxAge = -1
+	o.MaxAgeCeiling = 7200
+	require.Equal(t, "", o.GetMaxAge())
+}
+
+func TestHandler_ServeHTTP_PreflightStatus(t *testing.T) {
+	for _, status := range []int{0, http.StatusOK, http.StatusNoContent} {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"https://example.com"}
+		o.PreflightStatus = status
+		require.Nil(t, o.Validate())
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		if status == 0 {
+			status = http.StatusNoContent
+		}
+
+		require.Equal(t, status, rec.Code)
+		require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+		require.Equal(t, "0", rec.Header().Get("Content-Length"))
+		require.Empty(t, rec.Body.String())
+	}
+
+	for _, status := range []int{-1, 100, 199, 300, 404, 500} {
+		o := cors.NewOptions()
+		o.PreflightStatus = status
+		require.NotNil(t, o.Validate(), status)
+	}
+}
+
+func TestHandler_ServeHTTP_DeniedOrigin(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+	o.AllowHeaders = []string{"X-Requested-With"}
+	o.ExposeHeaders = []string{"X-Request-Id"}
+	o.AllowCredentials = true
+
+	serve := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		if method == http.MethodOptions {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	accessControl := func(rec *httptest.ResponseRecorder) []string {
+		var names []string
+
+		for name := range rec.Header() {
+			if strings.HasPrefix(name, "Access-Control-") {
+				names = append(names, name)
+			}
+		}
+
+		return names
+	}
+
+	rec := serve(http.MethodOptions, "https://example.com")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.NotEmpty(t, rec.Header().Get(cors.HeaderAllowHeaders))
+
+	for _, status := range []int{0, http.StatusNoContent, http.StatusForbidden} {
+		o.DeniedPreflightStatus = status
+
+		rec = serve(http.MethodOptions, "https://evil.example.com")
+
+		if status == 0 {
+			status = http.StatusNoContent
+		}
+
+		require.Equal(t, status, rec.Code)
+		require.Empty(t, accessControl(rec))
+		require.Equal(t, cors.HeaderOrigin, rec.Header().Get(cors.HeaderVary))
+	}
+
+	rec = serve(http.MethodGet, "https://example.com")
+	require.Equal(t, "X-Request-Id", rec.Header().Get(cors.HeaderExposeHeaders))
+
+	rec = serve(http.MethodGet, "https://evil.example.com")
+	require.Empty(t, accessControl(rec))
+	require.Equal(t, cors.HeaderOrigin, rec.Header().Get(cors.HeaderVary))
+}
+
+func TestHandler_ServeHTTP_ExplainDeniedPreflights(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.AllowHeaders = []string{"X-Requested-With"}
+	o.EnforceHeaders = true
+	o.EnforceMethods = true
+	o.DeniedPreflightStatus = http.StatusForbidden
+
+	serve := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+		req.Header.Set(cors.HeaderRequestMethod, method)
+
+		if headers != "" {
+			req.Header.Set(cors.HeaderRequestHeaders, headers)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := serve("https://evil.example.com", http.MethodPut, "")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, rec.Body.String())
+
+	o.ExplainDeniedPreflights = true
+
+	tests := []struct {
+		origin, method, headers string
+		expected                string
+	}{
+		{"https://evil.example.com", http.MethodPut, "", `not-allowed: origin "https://evil.example.com" is not allowed`},
+		{"https://example.com", http.MethodDelete, "", `method-not-allowed: method "DELETE" is not allowed`},
+		{"https://example.com", "PUT POST", "", "invalid-method: requested method is not a valid method"},
+		{"https://example.com", http.MethodPut, "x-requested-with, X-Token", `header-not-allowed: header "x-token" is not allowed`},
+	}
+
+	for _, test := range tests {
+		rec = serve(test.origin, test.method, test.headers)
+		require.Equal(t, http.StatusForbidden, rec.Code, test.expected)
+		require.Equal(t, test.expected+"\n", rec.Body.String())
+		require.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		require.Equal(t, strconv.Itoa(rec.Body.Len()), rec.Header().Get("Content-Length"))
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+	}
+
+	// No body where the status does not allow one.
+	o.DeniedPreflightStatus = http.StatusNoContent
+
+	rec = serve("https://evil.example.com", http.MethodPut, "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Empty(t, rec.Body.String())
+	require.Equal(t, "0", rec.Header().Get("Content-Length"))
+
+	rec = serve("https://example.com", http.MethodPut, "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Empty(t, rec.Body.String())
+}
+
+func TestOptions_AllowMethods_Normalized(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{"get", "Put, Patch", "DELTE"}
+	o.EnforceMethods = true
+	require.NoError(t, o.Validate())
+	require.Equal(t, "GET, PUT, PATCH, DELTE", o.GetAllowMethods())
+	require.Equal(t, []string{"get", "Put, Patch", "DELTE"}, o.AllowMethods)
+	require.Contains(t, o.Warnings(), `allowed method "DELTE" is not a known method`)
+
+	h := o.NewHandler()
+
+	for method, allowed := range map[string]bool{http.MethodPatch: true, "patch": false, http.MethodDelete: false} {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, method)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if allowed {
+			require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin), method)
+		} else {
+			require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin), method)
+		}
+	}
+
+	for _, methods := range [][]string{{"GET POST"}, {"GET, P(ST"}} {
+		o.AllowMethods = methods
+		require.Error(t, o.Validate(), methods)
+	}
+}
+
+func TestOptions_GetAllowMethods_IncludeDefaultMethods(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowMethods = []string{http.MethodPost, " PUT", http.MethodGet, http.MethodPost, "", "get"}
+	require.Equal(t, "POST, PUT, GET", o.GetAllowMethods())
+
+	o.IncludeDefaultMethods = true
+	require.Equal(t, "POST, PUT, GET, HEAD, OPTIONS", o.GetAllowMethods())
+	require.Equal(t, []string{http.MethodPost, " PUT", http.MethodGet, http.MethodPost, "", "get"}, o.AllowMethods)
+
+	o.AllowMethods = nil
+	require.Equal(t, "GET, HEAD, OPTIONS", o.GetAllowMethods())
+
+	o.AllowMethods = []string{http.MethodPut, "*"}
+	require.Equal(t, "*", o.GetAllowMethods())
+
+	// The added methods are enforced too.
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodPut}
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodOptions)
+
+	o.EnforceMethods = true
+
+	for include, status := range map[bool]int{false: http.StatusForbidden, true: http.StatusNoContent} {
+		o.IncludeDefaultMethods = include
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+		require.Equal(t, status, rec.Code, include)
+	}
+}
+
+func TestHandler_ServeHTTP_EnforceMethods(t *testing.T) {
+	preflight := func(o *cors.Options, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, method)
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+	o.AllowMethods = []string{http.MethodPut}
+	require.False(t, o.EnforceMethods)
+	require.Equal(t, http.StatusNoContent, preflight(o, http.MethodDelete).Code)
+
+	o.EnforceMethods = true
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut} {
+		rec := preflight(o, method)
+		require.Equal(t, http.StatusNoContent, rec.Code, method)
+		require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin), method)
+	}
+
+	for _, method := range []string{http.MethodDelete, "put", "get"} {
+		rec := preflight(o, method)
+		require.Equal(t, http.StatusForbidden, rec.Code, method)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin), method)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowMethods), method)
+		require.Equal(t, "Origin, Access-Control-Request-Method", rec.Header().Get(cors.HeaderVary), method)
+	}
+
+	o.PreflightFailureStatus = http.StatusMethodNotAllowed
+	require.Equal(t, http.StatusMethodNotAllowed, preflight(o, http.MethodDelete).Code)
+
+	o.PreflightFailureStatus = 0
+	require.Equal(t, http.StatusForbidden, preflight(o, http.MethodDelete).Code)
+
+	// The wildcard allows any method; with credentials, where browsers take
+	// it literally, the requested method is echoed instead.
+	o.AllowMethods = []string{"*"}
+	rec := preflight(o, http.MethodDelete)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "*", rec.Header().Get(cors.HeaderAllowMethods))
+
+	o.AllowCredentials = true
+	rec = preflight(o, http.MethodDelete)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, http.MethodDelete, rec.Header().Get(cors.HeaderAllowMethods))
+	require.Equal(t, "Origin, Access-Control-Request-Method", rec.Header().Get(cors.HeaderVary))
+
+	o.EnforceMethods = false
+	rec = preflight(o, http.MethodDelete)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, http.MethodDelete, rec.Header().Get(cors.HeaderAllowMethods))
+
+	// Invalid methods always fail, with no CORS headers and nothing echoed.
+	for _, method := range []string{"GET POST", "GET\x00", "GET,PUT", "G\u00e9T", "(GET)"} {
+		rec := preflight(o, method)
+		require.Equal(t, http.StatusForbidden, rec.Code, method)
+
+		for name, values := range rec.Header() {
+			require.False(t, strings.HasPrefix(name, "Access-Control-"), "%s: %s", method, name)
+			require.NotContains(t, strings.Join(values, ""), method, method)
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_ReflectRequestMethods(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+	o.ReflectRequestMethods = true
+
+	serve := func(method, requested string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		if requested != "" {
+			req.Header.Set(cors.HeaderRequestMethod, requested)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete, "PURGE", "patch"} {
+		rec := serve(http.MethodOptions, method)
+		require.Equal(t, http.StatusNoContent, rec.Code, method)
+		require.Equal(t, method, rec.Header().Get(cors.HeaderAllowMethods), method)
+		require.Equal(t, []string{"Origin, Access-Control-Request-Method"}, rec.Header().Values(cors.HeaderVary), method)
+	}
+
+	rec := serve(http.MethodOptions, "GET POST")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowMethods))
+
+	// Actual requests are unaffected.
+	rec = serve(http.MethodDelete, "PURGE")
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowMethods))
+	require.Equal(t, []string{cors.HeaderOrigin}, rec.Header().Values(cors.HeaderVary))
+
+	// Configured methods take precedence.
+	o.AllowMethods = []string{http.MethodPut}
+	o.EnforceMethods = true
+
+	rec = serve(http.MethodOptions, http.MethodPut)
+	require.Equal(t, http.MethodPut, rec.Header().Get(cors.HeaderAllowMethods))
+	require.Equal(t, http.StatusForbidden, serve(http.MethodOptions, http.MethodDelete).Code)
+
+	// Responses then only vary on the requested method through EnforceMethods.
+	require.Equal(t, []string{"Origin, Access-Control-Request-Method"}, rec.Header().Values(cors.HeaderVary))
+
+	o.EnforceMethods = false
+	require.Equal(t, []string{cors.HeaderOrigin}, serve(http.MethodOptions, http.MethodPut).Header().Values(cors.HeaderVary))
+}
+
+func TestIsMethod(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodDelete, "PROPFIND", "get", "*", "X-Custom.1"} {
+		require.True(t, cors.IsMethod(method), method)
+	}
+
+	for _, method := range []string{"", "GET POST", " GET", "GET\r\n", "GET\x7f", "G@T", "M\u00e9THOD"} {
+		require.False(t, cors.IsMethod(method), method)
+	}
+
+	o := cors.NewOptions()
+	o.AllowMethods = []string{http.MethodGet, " PUT ", "", "*"}
+	require.Nil(t, o.Validate())
+
+	o.AllowMethods = []string{"GET POST"}
+	require.NotNil(t, o.Validate())
+}
+
+func TestHandler_ServeHTTP_EnforceHeaders(t *testing.T) {
+	preflight := func(o *cors.Options, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+
+		for _, h := range headers {
+			req.Header.Add(cors.HeaderRequestHeaders, h)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"X-Requested-With", "Authorization"}
+
+	// Enforcement is opt-in.
+	require.Equal(t, http.StatusNoContent, preflight(o, "x-internal-token").Code)
+
+	o.EnforceHeaders = true
+
+	for _, headers := range [][]string{
+		nil,
+		{"x-requested-with"},
+		{"content-type,X-REQUESTED-WITH , authorization"},
+		{"accept, accept-language", "content-language,range"},
+	} {
+		rec := preflight(o, headers...)
+		require.Equal(t, http.StatusNoContent, rec.Code, headers)
+		require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin), headers)
+	}
+
+	for _, headers := range [][]string{
+		{""},
+		{"x-internal-token"},
+		{"x-requested-with,x-internal-token"},
+		{"x-requested-with", "x-internal-token"},
+	} {
+		rec := preflight(o, headers...)
+		require.Equal(t, http.StatusForbidden, rec.Code, headers)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin), headers)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowHeaders), headers)
+	}
+
+	// Safelisted headers must be listed without IncludeSafelistedHeaders.
+	o.IncludeSafelistedHeaders = false
+	require.Equal(t, http.StatusForbidden, preflight(o, "content-type").Code)
+	require.Equal(t, http.StatusNoContent, preflight(o, "x-requested-with").Code)
+
+	o.AllowHeaders = append(o.AllowHeaders, cors.HeaderContentType)
+	require.Equal(t, http.StatusNoContent, preflight(o, "content-type").Code)
+
+	o.IncludeSafelistedHeaders = true
+	require.NotContains(t, preflight(o, "accept").Header().Get(cors.HeaderAllowHeaders), cors.HeaderAccept)
+
+	// The wildcard allows any header but Authorization, and only without
+	// credentials.
+	o.AllowHeaders = []string{"*"}
+	require.Equal(t, http.StatusNoContent, preflight(o, "x-internal-token").Code)
+	require.Equal(t, http.StatusForbidden, preflight(o, "authorization").Code)
+
+	// With credentials, the requested headers are echoed instead.
+	o.AllowCredentials = true
+	require.Equal(t, http.StatusNoContent, preflight(o, "x-internal-token").Code)
+	require.Equal(t, http.StatusNoContent, preflight(o, "authorization").Code)
+}
+
+func TestHandler_ServeHTTP_RequestHeadersCase(t *testing.T) {
+	for _, tc := range []struct {
+		allow     []string
+		requested string
+		allowed   bool
+		echoed    string
+	}{
+		{[]string{"Authorization"}, "authorization", true, ""},
+		{[]string{"authorization"}, "Authorization", true, ""},
+		{[]string{"X-Request-ID"}, "x-request-id, AUTHORIZATION", false, ""},
+		{[]string{"X-Request-ID", "Authorization"}, "x-request-id, AUTHORIZATION", true, ""},
+		{[]string{" x-request-id "}, "X-Request-Id", true, ""},
+		{[]string{"*", "X-Request-ID"}, "x-request-id, x-tag", true, "X-Request-Id, x-tag"},
+		{[]string{"*", "Authorization", "AUTHORIZATION"}, "authorization", true, "Authorization"},
+	} {
+		for _, credentials := range []bool{false, true} {
+			o := cors.NewOptions()
+			o.AllowOrigins = []string{"https://example.com"}
+			o.AllowHeaders = tc.allow
+			o.AllowCredentials = credentials
+			o.EnforceHeaders = true
+
+			req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+			req.Header.Set(cors.HeaderOrigin, "https://example.com")
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+			req.Header.Set(cors.HeaderRequestHeaders, tc.requested)
+
+			rec := httptest.NewRecorder()
+			o.NewHandler().ServeHTTP(rec, req)
+
+			msg := fmt.Sprintf("%q %q credentials=%t", tc.allow, tc.requested, credentials)
+			require.Equal(t, tc.allowed, rec.Code == http.StatusNoContent, msg)
+
+			if tc.echoed != "" && credentials {
+				require.Equal(t, tc.echoed, rec.Header().Get(cors.HeaderAllowHeaders), msg)
+			}
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_EchoHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"*"}
+	o.AllowCredentials = true
+
+	h := o.NewHandler()
+
+	preflight := func(headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+
+		for _, h := range headers {
+			req.Header.Add(cors.HeaderRequestHeaders, h)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for expected, headers := range map[string][]string{
+		"":                                   nil,
+		"authorization, x-request-id":        {"authorization, x-request-id"},
+		"authorization, content-type, x-tag": {"authorization,, content-type", "x-tag"},
+		"x-valid":                            {"x-valid, x in:valid, x@"},
+	} {
+		rec := preflight(headers...)
+		require.Equal(t, http.StatusNoContent, rec.Code, headers)
+		require.Equal(t, expected, rec.Header().Get(cors.HeaderAllowHeaders), headers)
+		require.Contains(t, rec.Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders, headers)
+	}
+
+	// Without credentials, the wildcard is sent as is.
+	o.AllowCredentials = false
+	h = o.NewHandler()
+
+	rec := preflight("x-request-id")
+	require.Equal(t, "*", rec.Header().Get(cors.HeaderAllowHeaders))
+	require.NotContains(t, rec.Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders)
+}
+
+func TestHandler_ServeHTTP_ForbiddenHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+
+	preflight := func(headers string) string {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+		req.Header.Set(cors.HeaderRequestHeaders, headers)
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec.Header().Get(cors.HeaderAllowHeaders)
+	}
+
+	const requested = "cookie, x-a, Host, sec-fetch-mode, proxy-authorization, origin, authorization, dnt, x-b"
+
+	// Echoed with the wildcard and credentials.
+	o.AllowHeaders = []string{"*"}
+	o.AllowCredentials = true
+	require.Equal(t, "x-a, authorization, x-b", preflight(requested))
+
+	// Reflected.
+	o.AllowHeaders = nil
+	o.AllowCredentials = false
+	o.ReflectRequestHeaders = true
+	require.Equal(t, "x-a, authorization, x-b", preflight(requested))
+
+	// Intersected.
+	o.AllowHeaders = []string{"Cookie", "X-A", "Sec-Token"}
+	o.PartialAllowHeaders = true
+	require.Equal(t, "X-A", preflight(requested+", sec-token"))
+
+	// The configured list is the operator's choice.
+	o.PartialAllowHeaders = false
+	require.Equal(t, "Cookie, X-A, Sec-Token", preflight(requested))
+}
+
+func TestHandler_ServeHTTP_ReflectRequestHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.ReflectRequestHeaders = true
+	o.EnforceHeaders = true
+
+	preflight := func(headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+
+		if headers != "" {
+			req.Header.Set(cors.HeaderRequestHeaders, headers)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for _, credentials := range []bool{false, true} {
+		o.AllowCredentials = credentials
+
+		rec := preflight("Authorization, X-Request-ID,x-request-id")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, "authorization, x-request-id", rec.Header().Get(cors.HeaderAllowHeaders))
+		require.Contains(t, rec.Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders)
+
+		rec = preflight("")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowHeaders))
+		require.Contains(t, rec.Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders)
+
+		// Invalid names are never echoed, and fail under EnforceHeaders.
+		rec = preflight("x-valid, x@")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowHeaders))
+	}
+
+	// Configured headers take precedence.
+	o.AllowCredentials = false
+	o.AllowHeaders = []string{"X-Request-ID"}
+
+	rec := preflight("x-request-id")
+	require.Equal(t, "X-Request-Id", rec.Header().Get(cors.HeaderAllowHeaders))
+	require.Equal(t, "Origin, Access-Control-Request-Headers", rec.Header().Get(cors.HeaderVary))
+	require.Equal(t, http.StatusForbidden, preflight("x-other").Code)
+}
+
+func TestHandler_ServeHTTP_PartialAllowHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"X-Requested-With", "Authorization", "X-Tag"}
+	o.EnforceHeaders = true
+	o.PartialAllowHeaders = true
+
+	preflight := func(headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+
+		if headers != "" {
+			req.Header.Set(cors.HeaderRequestHeaders, headers)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	for headers, expected := range map[string]string{
+		"":                                   "",
+		"x-requested-with":                   "X-Requested-With",
+		"AUTHORIZATION, x-secret, x-tag":     "Authorization, X-Tag",
+		"x-secret, x-other":                  "",
+		"content-type, x-requested-with, x@": "content-type, X-Requested-With",
+	} {
+		rec := preflight(headers)
+		require.Equal(t, http.StatusNoContent, rec.Code, headers)
+		require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin), headers)
+		require.Equal(t, expected, rec.Header().Get(cors.HeaderAllowHeaders), headers)
+		require.Contains(t, rec.Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders, headers)
+	}
+
+	// The wildcard still leaves Authorization out without credentials.
+	o.AllowHeaders = []string{"*"}
+	require.Equal(t, "x-secret", preflight("authorization, x-secret").Header().Get(cors.HeaderAllowHeaders))
+
+	// All-or-nothing by default.
+	o.AllowHeaders = []string{"X-Tag"}
+	o.PartialAllowHeaders = false
+
+	rec := preflight("x-tag, x-secret")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowHeaders))
+
+	// EnforceHeaders alone makes the response vary on the requested headers.
+	rec = preflight("x-tag")
+	require.Equal(t, "X-Tag", rec.Header().Get(cors.HeaderAllowHeaders))
+	require.Contains(t, rec.Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders)
+
+	o.EnforceHeaders = false
+	require.NotContains(t, preflight("x-tag").Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders)
+}
+
+// taggingResponder modifies the preflight headers it is given before
+// responding, as a custom PreflightResponder may.
+type taggingResponder struct{}
+
+func (taggingResponder) RespondPreflight(rw http.ResponseWriter, req *cors.Request, header http.Header, status int) {
+	header.Add(cors.HeaderAllowHeaders, "X-Tagged")
+	cors.DefaultPreflightResponder.RespondPreflight(rw, req, header, status)
+}
+
+func TestHandler_ServeHTTP_PreflightCache(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.AllowHeaders = []string{"*"}
+	o.AllowCredentials = true
+	o.EnforceMethods = true
+	o.PreflightResponder = taggingResponder{}
+
+	preflight := func(h http.Handler, origin, method string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+		req.Header.Set(cors.HeaderRequestMethod, method)
+
+		for _, h := range headers {
+			req.Header.Add(cors.HeaderRequestHeaders, h)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	uncached := o.NewHandler()
+
+	o.PreflightCacheSize = 2
+	cached := o.NewHandler()
+
+	requests := [][]string{
+		{"https://example.com", http.MethodPut, "x-a, x-b"},
+		{"https://example.org", http.MethodPut, "x-a, x-b"},
+		{"https://example.com", http.MethodPut},
+		{"https://example.com", http.MethodPut, ""},
+		{"https://example.com", http.MethodDelete, "x-a"},
+		{"https://example.com", http.MethodPut, "x-a", "x-b"},
+		{"https://evil.example.com", http.MethodPut, "x-a"},
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, r := range requests {
+			expected := preflight(uncached, r[0], r[1], r[2:]...)
+			actual := preflight(cached, r[0], r[1], r[2:]...)
+			require.Equal(t, expected.Code, actual.Code, r)
+			require.Equal(t, expected.Header(), actual.Header(), r)
+		}
+	}
+
+	// A new handler starts with an empty cache.
+	o.AllowMethods = []string{http.MethodDelete}
+	cached = o.NewHandler()
+	require.Equal(t, http.StatusNoContent, preflight(cached, "https://example.com", http.MethodDelete, "x-a").Code)
+}
+
+func benchmarkPreflight(b *testing.B, cacheSize int) {
+	b.Helper()
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://example.org"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.AllowHeaders = []string{"Content-Type", "X-Requested-With", "X-Request-ID"}
+	o.AllowCredentials = true
+	o.EnforceHeaders = true
+	o.PartialAllowHeaders = true
+	o.PreflightCacheSize = cacheSize
+
+	h := o.NewHandler()
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+	req.Header.Set(cors.HeaderRequestHeaders, "content-type,x-request-id,x-requested-with")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkHandler_ServeHTTP_PreflightUncached(b *testing.B) {
+	benchmarkPreflight(b, 0)
+}
+
+func BenchmarkHandler_ServeHTTP_PreflightCached(b *testing.B) {
+	benchmarkPreflight(b, 1024)
+}
+
+func TestHandler_ServeHTTP_IncludeAuthorizationWithWildcard(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"*"}
+	o.EnforceHeaders = true
+
+	preflight := func(headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+		if headers != "" {
+			req.Header.Set(cors.HeaderRequestHeaders, headers)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	require.Equal(t, http.StatusForbidden, preflight("authorization").Code)
+
+	o.IncludeAuthorizationWithWildcard = true
+
+	for headers, expected := range map[string]string{
+		"":                            "*",
+		"x-request-id":                "*",
+		"authorization":               "*, Authorization",
+		"x-request-id, Authorization": "*, Authorization",
+	} {
+		rec := preflight(headers)
+		require.Equal(t, http.StatusNoContent, rec.Code, headers)
+		require.Equal(t, expected, rec.Header().Get(cors.HeaderAllowHeaders), headers)
+		require.Contains(t, rec.Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders, headers)
+	}
+
+	// Explicit lists are left untouched.
+	o.AllowHeaders = []string{"Authorization"}
+	require.Equal(t, "Authorization", preflight("authorization").Header().Get(cors.HeaderAllowHeaders))
+	require.Contains(t, preflight("authorization").Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders)
+
+	o.EnforceHeaders = false
+	require.NotContains(t, preflight("authorization").Header().Get(cors.HeaderVary), cors.HeaderRequestHeaders)
+}
+
+func TestHandler_ServeHTTP_MaxRequestedHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"*"}
+	o.AllowCredentials = true
+	o.MaxPreflightHeaderCount = 0
+
+	h := o.NewHandler()
+
+	preflight := func(headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+
+		for _, h := range headers {
+			req.Header.Add(cors.HeaderRequestHeaders, h)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	names := make([]string, cors.DefaultMaxRequestedHeaders)
+	for i := range names {
+		names[i] = "x-h" + strconv.Itoa(i)
+	}
+
+	rec := preflight(strings.Join(names, ", ") + ", ,")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Len(t, strings.Split(rec.Header().Get(cors.HeaderAllowHeaders), ","), cors.DefaultMaxRequestedHeaders)
+
+	rec = preflight(strings.Join(names, ", "), "x-extra")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowHeaders))
+
+	// A megabyte of header names fails without being echoed.
+	huge := strings.Repeat("x-a,", 1<<18)
+
+	start := time.Now()
+	rec = preflight(huge)
+	elapsed := time.Since(start)
+
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowHeaders))
+	require.Less(t, int64(elapsed), int64(100*time.Millisecond), fmt.Sprintf("took %v", elapsed))
+
+	o.MaxRequestedHeaders = 0
+	h = o.NewHandler()
+	require.Equal(t, http.StatusNoContent, preflight(strings.Join(names, ", "), "x-extra").Code)
+}
+
+func TestMiddleware_PassthroughPreflight(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"X-Requested-With"}
+	o.AllowMethods = []string{http.MethodGet, http.MethodPut}
+	o.EnforceMethods = true
+	o.PassthroughPreflight = true
+
+	var called int
+
+	h := o.NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		called++
+
+		if req.URL.Path == "/upload" {
+			rw.Header().Add(cors.HeaderAllowHeaders, "X-Requested-With, X-Upload-Id")
+		}
+
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	preflight := func(path, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com"+path, nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, method)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	rec := preflight("/api/", http.MethodPut)
+	require.Equal(t, 1, called)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"X-Requested-With"}, rec.Header().Values(cors.HeaderAllowHeaders))
+	require.Equal(t, "GET, PUT", rec.Header().Get(cors.HeaderAllowMethods))
+
+	// The backend's header replaces the middleware's rather than duplicating it.
+	rec = preflight("/upload", http.MethodPut)
+	require.Equal(t, 2, called)
+	require.Equal(t, []string{"X-Requested-With, X-Upload-Id"}, rec.Header().Values(cors.HeaderAllowHeaders))
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+
+	// Failed preflights are still terminated.
+	rec = preflight("/api/", http.MethodDelete)
+	require.Equal(t, 2, called)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestMiddleware_OptionsPassthrough(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodGet, http.MethodPut, "*"}
+
+	var called int
+
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called++ })
+
+	options := func(h http.Handler, origin, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+		}
+
+		if method != "" {
+			req.Header.Set(cors.HeaderRequestMethod, method)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	h := o.NewMiddleware(next)
+
+	options(h, "", "")
+	require.Equal(t, 1, called)
+
+	rec := options(h, "https://example.com", "")
+	require.Equal(t, 2, called)
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+
+	o.OptionsPassthrough = false
+	h = o.NewMiddleware(next)
+
+	for _, origin := range []string{"", "https://example.com"} {
+		rec = options(h, origin, "")
+		require.Equal(t, 2, called, origin)
+		require.Equal(t, http.StatusNoContent, rec.Code, origin)
+		require.Equal(t, "GET, PUT, OPTIONS", rec.Header().Get(cors.HeaderAllow), origin)
+	}
+
+	// Preflights are unaffected.
+	rec = options(h, "https://example.com", http.MethodPut)
+	require.Equal(t, 2, called)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllow))
+	require.Equal(t, "*", rec.Header().Get(cors.HeaderAllowMethods))
+}
+
+func TestMiddleware_LenientPreflight(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.AllowHeaders = []string{"X-Requested-With"}
+	o.MaxAge = 600
+
+	var called int
+
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called++ })
+
+	options := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewMiddleware(next).ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// Spec-correct by default: passed on as an actual request.
+	rec := options("https://example.com")
+	require.Equal(t, 1, called)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowMethods))
+
+	o.LenientPreflight = true
+
+	rec = options("https://example.com")
+	require.Equal(t, 1, called)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "PUT", rec.Header().Get(cors.HeaderAllowMethods))
+	require.Equal(t, "X-Requested-With", rec.Header().Get(cors.HeaderAllowHeaders))
+	require.Equal(t, "600", rec.Header().Get(cors.HeaderMaxAge))
+
+	// Denied origins are answered as denied preflights.
+	rec = options("https://evil.example.com")
+	require.Equal(t, 1, called)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+
+	// Without an Origin, it is not a CORS request at all.
+	options("")
+	require.Equal(t, 2, called)
+
+	// Nothing is reflected when no method is requested.
+	o.AllowMethods = nil
+	o.ReflectRequestMethods = true
+
+	rec = options("https://example.com")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Empty(t, rec.Header().Values(cors.HeaderAllowMethods))
+}
+
+func TestMiddleware_VaryMerged(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+
+	serve := func(origin string, existing []string, backend func(http.Header)) []string {
+		next := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			backend(rw.Header())
+			_, _ = rw.Write([]byte("ok"))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+		}
+
+		rec := httptest.NewRecorder()
+		rec.Header()[cors.HeaderVary] = existing
+		o.NewMiddleware(next).ServeHTTP(rec, req)
+
+		return rec.Header().Values(cors.HeaderVary)
+	}
+
+	add := func(values ...string) func(http.Header) {
+		return func(header http.Header) {
+			for _, v := range values {
+				header.Add(cors.HeaderVary, v)
+			}
+		}
+	}
+
+	set := func(value string) func(http.Header) {
+		return func(header http.Header) { header.Set(cors.HeaderVary, value) }
+	}
+
+	tests := []struct {
+		origin   string
+		existing []string
+		backend  func(http.Header)
+		expected []string
+	}{
+		{"https://example.com", nil, add(), []string{"Origin"}},
+		{"https://example.com", nil, add("Origin"), []string{"Origin"}},
+		{"https://example.com", nil, add("origin"), []string{"Origin"}},
+		{"https://example.com", nil, add("Accept-Encoding"), []string{"Origin, Accept-Encoding"}},
+		{"https://example.com", nil, add("Accept-Encoding", "Origin, Accept"), []string{"Origin, Accept-Encoding, Accept"}},
+		{"https://example.com", nil, set("Accept-Encoding"), []string{"Accept-Encoding, Origin"}},
+		{"https://example.com", []string{"Accept-Language"}, add("Accept-Encoding", "Accept-Language"), []string{"Accept-Language, Origin, Accept-Encoding"}},
+		{"https://example.com", nil, add("*"), []string{"Origin", "*"}},
+		{"https://other.example.com", nil, add("Origin"), []string{"Origin"}},
+		{"", nil, add("Accept-Encoding", "Origin"), []string{"Origin, Accept-Encoding"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, serve(test.origin, test.existing, test.backend), "%q %v", test.origin, test.existing)
+	}
+
+	// Without a Vary of its own, the middleware leaves that of the backend alone.
+	o.AllowOrigins = []string{cors.HeaderValueWildcard}
+	require.Equal(t, []string{"Accept-Encoding", "Accept-Encoding"}, serve("https://example.com", nil, add("Accept-Encoding", "Accept-Encoding")))
+}
+
+func TestMiddleware_VaryExtra(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.VaryExtra = []string{"x-tenant", " Origin", "", "X-Tenant"}
+	require.NoError(t, o.Validate())
+	require.Equal(t, "Origin, X-Tenant", o.GetVary())
+
+	serve := func(origin, method string) []string {
+		next := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Add(cors.HeaderVary, "Accept-Encoding, x-tenant")
+		})
+
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewMiddleware(next).ServeHTTP(rec, req)
+
+		return rec.Header().Values(cors.HeaderVary)
+	}
+
+	require.Equal(t, []string{"Origin, X-Tenant, Accept-Encoding"}, serve("https://example.com", http.MethodGet))
+	require.Equal(t, []string{"Origin, X-Tenant, Accept-Encoding"}, serve("", http.MethodGet))
+	require.Equal(t, []string{"Origin, X-Tenant"}, serve("https://example.com", http.MethodOptions))
+
+	// Without origins to vary on, the extra names are still sent.
+	o.AllowOrigins = []string{cors.HeaderValueWildcard}
+	o.VaryExtra = []string{"X-Tenant"}
+	require.Equal(t, "X-Tenant", o.GetVary())
+	require.Equal(t, []string{"X-Tenant, Accept-Encoding"}, serve("https://example.com", http.MethodGet))
+
+	// Names overlapping the built-in ones are listed once.
+	o.AllowOrigins = []string{"https://example.com"}
+	o.VaryExtra = []string{"access-control-request-method", "X-Tenant", "Access-Control-Request-Headers"}
+	o.EnforceMethods = true
+	o.EnforceHeaders = true
+	require.Equal(t, "Origin, Access-Control-Request-Method, X-Tenant, Access-Control-Request-Headers", o.GetPreflightVary())
+	require.Equal(t, []string{"Origin, Access-Control-Request-Method, X-Tenant, Access-Control-Request-Headers"},
+		serve("https://example.com", http.MethodOptions))
+
+	for _, name := range []string{"X Tenant", "*", "X-Tenant:"} {
+		o.VaryExtra = []string{name}
+		require.Error(t, o.Validate(), name)
+	}
+}
+
+func TestHandler_ServeHTTP_AllowCredentialsOnlyWhenAllowed(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowCredentials = true
+
+	h := o.NewHandler()
+
+	serve := func(method, origin string) http.Header {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+		}
+
+		if method == http.MethodOptions {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		// No Origin
+		header := serve(method, "")
+		require.Empty(t, header.Values(cors.HeaderAllowOrigin), method)
+		require.Empty(t, header.Values(cors.HeaderAllowCredentials), method)
+
+		// Disallowed origin
+		header = serve(method, "https://example.org")
+		require.Empty(t, header.Values(cors.HeaderAllowOrigin), method)
+		require.Empty(t, header.Values(cors.HeaderAllowCredentials), method)
+
+		// Allowed origin
+		header = serve(method, "https://example.com")
+		require.Equal(t, "https://example.com", header.Get(cors.HeaderAllowOrigin), method)
+		require.Equal(t, []string{"true"}, header.Values(cors.HeaderAllowCredentials), method)
+	}
+}
+
+func TestHandler_ServeHTTP_ResourcePolicy(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+
+	serve := func(method, origin string, existing http.Header) http.Header {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+		}
+
+		if method == http.MethodOptions {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		}
+
+		rec := httptest.NewRecorder()
+		for k, v := range existing {
+			rec.Header()[k] = v
+		}
+
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	// Absent by default.
+	require.Empty(t, serve(http.MethodGet, "https://example.com", nil).Values(cors.HeaderResourcePolicy))
+	require.Empty(t, serve(http.MethodGet, "", nil).Values(cors.HeaderResourcePolicy))
+
+	o.ResourcePolicy = cors.ResourcePolicyCrossOrigin
+	require.NoError(t, o.Validate())
+
+	require.Equal(t, []string{"cross-origin"}, serve(http.MethodGet, "https://example.com", nil).Values(cors.HeaderResourcePolicy))
+	require.Equal(t, []string{"cross-origin"}, serve(http.MethodGet, "https://example.org", nil).Values(cors.HeaderResourcePolicy))
+	require.Equal(t, []string{"cross-origin"}, serve(http.MethodGet, "", nil).Values(cors.HeaderResourcePolicy))
+	require.Empty(t, serve(http.MethodOptions, "https://example.com", nil).Values(cors.HeaderResourcePolicy))
+
+	existing := http.Header{cors.HeaderResourcePolicy: {"same-origin"}}
+	require.Equal(t, []string{"cross-origin"}, serve(http.MethodGet, "https://example.com", existing).Values(cors.HeaderResourcePolicy))
+
+	o.PreserveExistingHeaders = true
+	require.Equal(t, []string{"same-origin"}, serve(http.MethodGet, "https://example.com", existing).Values(cors.HeaderResourcePolicy))
+	require.Equal(t, []string{"same-origin"}, serve(http.MethodGet, "", existing).Values(cors.HeaderResourcePolicy))
+
+	for _, policy := range []string{"same-site", "same-origin"} {
+		o.ResourcePolicy = policy
+		require.NoError(t, o.Validate(), policy)
+	}
+
+	for _, policy := range []string{"cross-site", "Same-Origin", " same-site", "*"} {
+		o.ResourcePolicy = policy
+		require.Error(t, o.Validate(), policy)
+	}
+}
+
+func TestMiddleware_ErrorResponses(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowCredentials = true
+
+	serve := func(backend http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		rec := httptest.NewRecorder()
+		h := o.NewMiddleware(backend)
+
+		// recovers panics like the servers and middlewares further up do
+		func() {
+			defer func() {
+				if recover() != nil {
+					http.Error(rec, "internal error", http.StatusInternalServerError)
+				}
+			}()
+
+			h.ServeHTTP(rec, req)
+		}()
+
+		return rec
+	}
+
+	tests := map[string]http.HandlerFunc{
+		"unauthorized": func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Del(cors.HeaderAllowOrigin)
+			rw.Header().Del(cors.HeaderAllowCredentials)
+			rw.Header().Del(cors.HeaderVary)
+			rw.WriteHeader(http.StatusUnauthorized)
+		},
+		"error": func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set(cors.HeaderAllowOrigin, "*")
+			http.Error(rw, "internal error", http.StatusInternalServerError)
+		},
+		"implicit": func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Del(cors.HeaderAllowOrigin)
+			_, _ = rw.Write([]byte("ok"))
+		},
+		"panic": func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Del(cors.HeaderAllowOrigin)
+			rw.Header().Del(cors.HeaderVary)
+			panic("backend failure")
+		},
+	}
+
+	for name, backend := range tests {
+		rec := serve(backend)
+		require.Equal(t, []string{"https://example.com"}, rec.Header().Values(cors.HeaderAllowOrigin), name)
+		require.Equal(t, []string{"true"}, rec.Header().Values(cors.HeaderAllowCredentials), name)
+		require.Equal(t, []string{cors.HeaderOrigin}, rec.Header().Values(cors.HeaderVary), name)
+	}
+
+	require.Equal(t, http.StatusInternalServerError, serve(tests["panic"]).Code)
+
+	// Protocol upgrades still reach the underlying writer.
+	serve(func(rw http.ResponseWriter, _ *http.Request) {
+		hijacker, ok := rw.(http.Hijacker)
+		require.True(t, ok)
+
+		_, _, err := hijacker.Hijack()
+		require.Error(t, err)
+	})
+
+	// When the backend takes precedence, its changes are kept.
+	o.PreserveExistingHeaders = true
+
+	rec := serve(tests["error"])
+	require.Equal(t, []string{"*"}, rec.Header().Values(cors.HeaderAllowOrigin))
+
+	rec = serve(tests["unauthorized"])
+	require.Equal(t, []string{"https://example.com"}, rec.Header().Values(cors.HeaderAllowOrigin))
+}
+
+func TestMiddleware_HeaderPhase(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.ExposeHeaders = []string{"X-Request-Id"}
+	o.AllowCredentials = true
+
+	var seen http.Header
+
+	backend := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		seen = rw.Header().Clone()
+
+		rw.Header().Set(cors.HeaderAllowOrigin, "*")
+		rw.Header().Set(cors.HeaderExposeHeaders, "X-Backend")
+		rw.Header().Add(cors.HeaderVary, "Accept-Encoding")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	silent := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Del(cors.HeaderAllowOrigin)
+	})
+
+	serve := func(method string, next http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		if method == http.MethodOptions {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewMiddleware(next).ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// The backend sees the headers of the middleware, and can replace them.
+	header := serve(http.MethodGet, backend).Header()
+	require.Equal(t, "https://example.com", seen.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "https://example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"X-Backend"}, header.Values(cors.HeaderExposeHeaders))
+	require.Equal(t, []string{"Origin, Accept-Encoding"}, header.Values(cors.HeaderVary))
+
+	o.HeaderPhase = cors.HeaderPhaseResponse
+	require.NoError(t, o.Validate())
+
+	// The headers of the middleware are applied after those of the backend.
+	header = serve(http.MethodGet, backend).Header()
+	require.Empty(t, seen.Values(cors.HeaderAllowOrigin))
+	require.Empty(t, seen.Values(cors.HeaderVary))
+	require.Equal(t, []string{"https://example.com"}, header.Values(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"true"}, header.Values(cors.HeaderAllowCredentials))
+	require.Equal(t, []string{"X-Request-Id"}, header.Values(cors.HeaderExposeHeaders))
+	require.Equal(t, []string{"Accept-Encoding, Origin"}, header.Values(cors.HeaderVary))
+
+	// Even when the backend writes nothing.
+	header = serve(http.MethodGet, silent).Header()
+	require.Equal(t, []string{"https://example.com"}, header.Values(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"X-Request-Id"}, header.Values(cors.HeaderExposeHeaders))
+
+	// Preflight responses are written by the middleware as before.
+	rec := serve(http.MethodOptions, backend)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "PUT", rec.Header().Get(cors.HeaderAllowMethods))
+	require.Equal(t, cors.HeaderOrigin, rec.Header().Get(cors.HeaderVary))
+
+	// The headers the backend set are kept under PreserveExistingHeaders.
+	o.PreserveExistingHeaders = true
+
+	header = serve(http.MethodGet, backend).Header()
+	require.Equal(t, []string{"*"}, header.Values(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"X-Backend"}, header.Values(cors.HeaderExposeHeaders))
+	require.Equal(t, []string{"true"}, header.Values(cors.HeaderAllowCredentials))
+
+	o.HeaderPhase = "after"
+	require.Error(t, o.Validate())
+}
+
+func TestMiddleware_StripUpstreamCORSHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowCredentials = true
+	o.HeaderPhase = cors.HeaderPhaseResponse
+	o.StripUpstreamCORSHeaders = true
+	require.NoError(t, o.Validate())
+
+	// behaves like Express with cors() enabled
+	backend := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set(cors.HeaderAllowOrigin, "*")
+		rw.Header().Set(cors.HeaderAllowCredentials, "false")
+		rw.Header().Set(cors.HeaderExposeHeaders, "X-Powered-By")
+		rw.Header().Set("X-Powered-By", "Express")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(origin string) http.Header {
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		rec := httptest.NewRecorder()
+		o.NewMiddleware(backend).ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	header := serve("https://example.com")
+	require.Equal(t, []string{"https://example.com"}, header.Values(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"true"}, header.Values(cors.HeaderAllowCredentials))
+	require.Empty(t, header.Values(cors.HeaderExposeHeaders))
+	require.Equal(t, "Express", header.Get("X-Powered-By"))
+
+	// Denied origins get none of the backend's CORS headers either.
+	header = serve("https://example.org")
+	require.Empty(t, header.Values(cors.HeaderAllowOrigin))
+	require.Empty(t, header.Values(cors.HeaderAllowCredentials))
+
+	// Scoped to the headers the middleware writes, the others are kept.
+	o.StripManagedHeadersOnly = true
+
+	header = serve("https://example.com")
+	require.Equal(t, []string{"https://example.com"}, header.Values(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"true"}, header.Values(cors.HeaderAllowCredentials))
+	require.Equal(t, []string{"X-Powered-By"}, header.Values(cors.HeaderExposeHeaders))
+
+	header = serve("https://example.org")
+	require.Equal(t, []string{"*"}, header.Values(cors.HeaderAllowOrigin))
+
+	o.HeaderPhase = cors.HeaderPhaseRequest
+	require.Error(t, o.Validate())
+}
+
+func TestMiddleware_PreserveExistingHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.AllowHeaders = []string{"X-Requested-With"}
+	o.ExposeHeaders = []string{"X-Request-Id"}
+	o.AllowCredentials = true
+
+	// backend exposes its own headers, as a download endpoint would.
+	backend := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Add(cors.HeaderExposeHeaders, "Content-Disposition")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(preflight bool, existing http.Header) http.Header {
+		method := http.MethodGet
+		if preflight {
+			method = http.MethodOptions
+		}
+
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		if preflight {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		}
+
+		rec := httptest.NewRecorder()
+		for k, v := range existing {
+			rec.Header()[k] = v
+		}
+
+		o.NewMiddleware(backend).ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	existing := http.Header{
+		cors.HeaderAllowOrigin:      {"https://other.example.com"},
+		cors.HeaderAllowCredentials: {"false"},
+		cors.HeaderAllowMethods:     {"PATCH"},
+		cors.HeaderAllowHeaders:     {"X-Other"},
+		cors.HeaderMaxAge:           {"60"},
+	}
+
+	// By default, the middleware overwrites existing headers, and the backend
+	// adds to them.
+	header := serve(true, existing)
+	require.Equal(t, "https://example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "true", header.Get(cors.HeaderAllowCredentials))
+	require.Equal(t, "PUT", header.Get(cors.HeaderAllowMethods))
+	require.Equal(t, "X-Requested-With", header.Get(cors.HeaderAllowHeaders))
+	require.Equal(t, "5", header.Get(cors.HeaderMaxAge))
+
+	header = serve(false, nil)
+	require.Equal(t, []string{"X-Request-Id", "Content-Disposition"}, header.Values(cors.HeaderExposeHeaders))
+
+	o.PreserveExistingHeaders = true
+
+	// Existing headers take precedence, one by one.
+	for name, value := range existing {
+		header = serve(true, http.Header{name: value})
+		require.Equal(t, value, header.Values(name), name)
+	}
+
+	header = serve(true, nil)
+	require.Equal(t, "https://example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "PUT", header.Get(cors.HeaderAllowMethods))
+	require.Equal(t, "5", header.Get(cors.HeaderMaxAge))
+
+	header = serve(false, http.Header{cors.HeaderAllowOrigin: {"https://other.example.com"}})
+	require.Equal(t, []string{"https://other.example.com"}, header.Values(cors.HeaderAllowOrigin))
+	require.Equal(t, "true", header.Get(cors.HeaderAllowCredentials))
+
+	// Headers the backend sets or adds to replace those of the middleware.
+	header = serve(false, nil)
+	require.Equal(t, "https://example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, []string{"Content-Disposition"}, header.Values(cors.HeaderExposeHeaders))
+
+	header = serve(false, http.Header{cors.HeaderExposeHeaders: {"X-Outer"}})
+	require.Equal(t, []string{"Content-Disposition"}, header.Values(cors.HeaderExposeHeaders))
+}
+
+func TestMiddleware_OversizedPreflight(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.MaxPreflightHeaderCount = 8
+	o.MaxPreflightBodyBytes = 16
+
+	var forwarded int
+
+	h := o.NewMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ }))
+
+	preflight := func(body io.Reader) *http.Request {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", body)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodPost)
+		req.Header.Set(cors.HeaderRequestHeaders, "content-type")
+
+		return req
+	}
+
+	// A browser-sized preflight is unaffected.
+	req := preflight(nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "*/*")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+
+	req = preflight(nil)
+	for i := 0; i < 6; i++ {
+		req.Header.Add("X-Scan", strconv.Itoa(i))
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusRequestHeaderFieldsTooLarge, rec.Code)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+
+	req = preflight(strings.NewReader(strings.Repeat("a", 17)))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	body := &countingReader{}
+	req = preflight(body)
+	req.ContentLength = -1
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.LessOrEqual(t, body.n, 512, "body reads must be bounded")
+
+	req = preflight(strings.NewReader(strings.Repeat("a", 16)))
+	req.ContentLength = -1
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	require.Equal(t, 0, forwarded)
+	require.Equal(t, uint64(3), o.Stats().OversizedPreflights)
+
+	o.PreflightHeaderCountStatus = http.StatusBadRequest
+	h = o.NewMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { forwarded++ }))
+	req = preflight(nil)
+	req.Header["X-Scan"] = make([]string, 8)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestMiddleware_StripOriginHeader(t *testing.T) {
+	for _, stripRequestHeaders := range []bool{false, true} {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"https://example.com"}
+		o.StripOriginHeader = true
+		o.StripRequestHeaders = stripRequestHeaders
+
+		var backend http.Header
+
+		h := o.NewMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			backend = req.Header
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		req.Header.Set("X-Request-Id", "42")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+		require.Empty(t, backend.Get(cors.HeaderOrigin))
+		require.Equal(t, stripRequestHeaders, backend.Get(cors.HeaderRequestMethod) == "")
+		require.Equal(t, "42", backend.Get("X-Request-Id"))
+		require.Equal(t, "https://example.com", req.Header.Get(cors.HeaderOrigin))
+	}
+}
+
+func TestOptions_GetAllowOrigin_AllowDomains(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com":             "https://example.com",
+		"https://api.example.com":         "https://api.example.com",
+		"https://a.b.eu.example.com:8443": "https://a.b.eu.example.com:8443",
+		"https://APP.Example.dev":         "https://APP.Example.dev",
+		"https://badexample.com":          "",
+		"https://example.com.evil.com":    "",
+		"http://api.example.com":          "",
+		"null":                            "",
+	}
+
+	for _, build := range []bool{false, true} {
+		o := cors.NewOptions()
+		o.AllowDomains = []string{"example.com", "Example.dev."}
+		require.Nil(t, o.Validate())
+
+		if build {
+			o.NewHandler()
+		}
+
+		for origin, expected := range tests {
+			req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+			req.Header.Set(cors.HeaderOrigin, origin)
+
+			require.Equal(t, expected, o.GetAllowOrigin(req), origin)
+		}
+
+		require.Equal(t, cors.HeaderOrigin, o.GetVary())
+
+		o.AllowDomainsAnyScheme = true
+
+		if build {
+			o.NewHandler()
+		}
+
+		req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+		req.Header.Set(cors.HeaderOrigin, "http://api.example.com")
+		require.Equal(t, "http://api.example.com", o.GetAllowOrigin(req))
+	}
+}
+
+func TestOptions_Validate_AllowDomains(t *testing.T) {
+	for _, domain := range []string{"", "com", "co.uk", "GitHub.io", "*.example.com", "https://example.com", "example.com:443"} {
+		o := cors.NewOptions()
+		o.AllowDomains = []string{domain}
+		require.NotNil(t, o.Validate(), domain)
+	}
+}
+
+func TestMiddleware_ForwardOrigin(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.ForwardOrigin = true
+	o.StripOriginHeader = true
+
+	var backend http.Header
+
+	h := o.NewMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		backend = req.Header
+	}))
+
+	tests := []struct {
+		method   string
+		origin   string
+		spoofed  string
+		expected string
+	}{
+		{http.MethodGet, "https://example.com", "", "https://example.com"},
+		{http.MethodPost, "https://evil.com", "https://example.com", "https://evil.com"},
+		{http.MethodOptions, "https://example.com", "https://spoofed.com", "https://example.com"},
+		{http.MethodGet, "", "https://spoofed.com", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, tt.origin)
+
+		if tt.spoofed != "" {
+			req.Header.Set(cors.DefaultForwardOriginHeader, tt.spoofed)
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		require.Equal(t, tt.expected, backend.Get(cors.DefaultForwardOriginHeader), tt.origin)
+		require.Empty(t, backend.Get(cors.HeaderOrigin), tt.origin)
+	}
+
+	o.ForwardOriginHeader = "X-Client-Origin"
+	h = o.NewMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		backend = req.Header
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, "https://example.com", backend.Get("X-Client-Origin"))
+}
@@ -0,0 +1,54 @@
+// Package corstest provides utilities for testing code built around the cors
+// package.
+package corstest
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/quintinheard/traefik-cors/cors"
+)
+
+// RecordedPreflight is a preflight response captured by a RecordingResponder.
+type RecordedPreflight struct {
+	Method string
+	URL    string
+	Origin string
+	Header http.Header
+	Status int
+}
+
+// RecordingResponder is a cors.PreflightResponder that records the preflight
+// responses it is asked to write instead of writing them. It is safe for
+// concurrent use.
+type RecordingResponder struct {
+	mu         sync.Mutex
+	preflights []RecordedPreflight
+}
+
+// NewRecordingResponder returns an empty RecordingResponder.
+func NewRecordingResponder() *RecordingResponder {
+	return &RecordingResponder{}
+}
+
+// RespondPreflight implements cors.PreflightResponder. Nothing is written to rw.
+func (r *RecordingResponder) RespondPreflight(_ http.ResponseWriter, req *cors.Request, header http.Header, status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.preflights = append(r.preflights, RecordedPreflight{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Origin: req.Header.Get(cors.HeaderOrigin),
+		Header: header.Clone(),
+		Status: status,
+	})
+}
+
+// Preflights returns a copy of the preflight responses recorded so far.
+func (r *RecordingResponder) Preflights() []RecordedPreflight {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]RecordedPreflight(nil), r.preflights...)
+}
@@ -0,0 +1,37 @@
+package corstest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/quintinheard/traefik-cors/cors/corstest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingResponder(t *testing.T) {
+	responder := corstest.NewRecordingResponder()
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodGet}
+	o.PreflightResponder = responder
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+	req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+	rec := httptest.NewRecorder()
+	o.NewHandler().ServeHTTP(rec, req)
+
+	preflights := responder.Preflights()
+	require.Len(t, preflights, 1)
+	require.Equal(t, "https://example.com", preflights[0].Origin)
+	require.Equal(t, http.StatusNoContent, preflights[0].Status)
+	require.Equal(t, "GET", preflights[0].Header.Get(cors.HeaderAllowMethods))
+
+	// nothing preflight specific reaches the response writer
+	require.Equal(t, "", rec.Header().Get(cors.HeaderAllowMethods))
+}
@@ -0,0 +1,54 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// setDebug sets the DebugHeader of header, under Debug, to a summary of the
+// decision on the request r for reason, such as "origin=denied(not-allowed)"
+// or "preflight=allowed;method=PUT;hdrs=2". Preflights also get the requested
+// method, when valid, and the number of requested headers, unless there are
+// too many to count. The summary only holds reasons and what the request
+// itself sent, never entries of the configuration.
+func (o *Options) setDebug(header http.Header, r *Request, reason Reason) {
+	if !o.Debug {
+		return
+	}
+
+	name := o.DebugHeader
+	if name == "" {
+		name = DefaultDebugHeader
+	}
+
+	var b strings.Builder
+
+	if o.isPreflight(r) {
+		b.WriteString("preflight=")
+	} else {
+		b.WriteString("origin=")
+	}
+
+	if reason == ReasonAllowed {
+		b.WriteString("allowed")
+	} else {
+		b.WriteString("denied(")
+		b.WriteString(string(reason))
+		b.WriteByte(')')
+	}
+
+	if o.isPreflight(r) {
+		if method := r.RequestedMethod(); IsMethod(method) {
+			b.WriteString(";method=")
+			b.WriteString(method)
+		}
+
+		if reason != ReasonTooManyHeaders {
+			b.WriteString(";hdrs=")
+			b.WriteString(strconv.Itoa(len(r.RequestedHeaders())))
+		}
+	}
+
+	header.Set(name, b.String())
+}
@@ -0,0 +1,91 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_ServeHTTP_Debug(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"X-Token"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.EnforceHeaders = true
+	o.Debug = true
+
+	serve := func(origin, method, headers string) http.Header {
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		if origin != "" {
+			req.Header.Set(cors.HeaderOrigin, origin)
+		}
+
+		if method != "" {
+			req.Method = http.MethodOptions
+			req.Header.Set(cors.HeaderRequestMethod, method)
+		}
+
+		if headers != "" {
+			req.Header.Set(cors.HeaderRequestHeaders, headers)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	tests := []struct {
+		origin   string
+		method   string
+		headers  string
+		expected string
+	}{
+		{"https://example.com", "", "", "origin=allowed"},
+		{"https://evil.example.com", "", "", "origin=denied(not-allowed)"},
+		{"", "", "", ""},
+		{"https://example.com", http.MethodPut, "", "preflight=allowed;method=PUT;hdrs=0"},
+		{"https://example.com", http.MethodPut, "x-token, content-type", "preflight=allowed;method=PUT;hdrs=2"},
+		{"https://example.com", http.MethodPut, "x-token, x-secret", "preflight=denied(header-not-allowed);method=PUT;hdrs=2"},
+		{"https://example.com", "GET POST", "", "preflight=denied(invalid-method);hdrs=0"},
+		{"https://evil.example.com", http.MethodGet, "", "preflight=denied(not-allowed);method=GET;hdrs=0"},
+	}
+
+	for _, test := range tests {
+		header := serve(test.origin, test.method, test.headers)
+		require.Equal(t, test.expected, header.Get(cors.DefaultDebugHeader), "%q %q %q", test.origin, test.method, test.headers)
+
+		// The configuration is never disclosed.
+		require.NotContains(t, header.Get(cors.DefaultDebugHeader), "example.com")
+	}
+
+	// Cached preflight headers are not modified.
+	o.PreflightCacheSize = 8
+	require.Equal(t, "preflight=allowed;method=PUT;hdrs=1", serve("https://example.com", http.MethodPut, "x-token").Get(cors.DefaultDebugHeader))
+	require.Equal(t, "preflight=allowed;method=PUT;hdrs=2", serve("https://example.com", http.MethodPut, "x-token, accept").Get(cors.DefaultDebugHeader))
+
+	o.DebugHeader = "X-Why"
+	require.NoError(t, o.Validate())
+	require.Equal(t, "origin=allowed", serve("https://example.com", "", "").Get("X-Why"))
+
+	o.DebugHeader = "X Why"
+	require.Error(t, o.Validate())
+
+	// Strictly opt-in.
+	o.DebugHeader = ""
+	o.Debug = false
+
+	for _, header := range []http.Header{
+		serve("https://example.com", "", ""),
+		serve("https://evil.example.com", http.MethodPut, ""),
+		serve("https://example.com", http.MethodPut, "x-token"),
+	} {
+		for name := range header {
+			require.False(t, strings.HasPrefix(name, "X-Cors"), name)
+		}
+	}
+}
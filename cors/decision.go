@@ -0,0 +1,102 @@
+package cors
+
+// Reason explains why an origin was or was not allowed, or why a preflight
+// request failed.
+type Reason string
+
+const (
+	// ReasonAllowed means the origin is allowed.
+	ReasonAllowed Reason = "allowed"
+	// ReasonNoOrigin means the request has no Origin header, or an empty one,
+	// so it is not a CORS request.
+	ReasonNoOrigin Reason = "no-origin"
+	// ReasonNotAllowed means the origin is not allowed by any configured entry.
+	ReasonNotAllowed Reason = "not-allowed"
+	// ReasonOversizedOrigin means the Origin exceeds MaxOriginLength.
+	ReasonOversizedOrigin Reason = "oversized-origin"
+	// ReasonInsecureOrigin means RequireSecureOrigins rejected the Origin.
+	ReasonInsecureOrigin Reason = "insecure-origin"
+	// ReasonUnsafeOrigin means the origin was allowed but cannot be echoed
+	// safely, as it holds control characters, whitespace or commas.
+	ReasonUnsafeOrigin Reason = "unsafe-origin"
+	// ReasonInconsistentRequest means ParanoidChecks found the request
+	// inconsistent, so its Origin is not trusted.
+	ReasonInconsistentRequest Reason = "inconsistent-request"
+	// ReasonMatcherPanic means an OriginMatcher panicked while matching.
+	ReasonMatcherPanic Reason = "matcher-panic"
+
+	// ReasonInvalidMethod means a preflight requested a value that is not a
+	// method.
+	ReasonInvalidMethod Reason = "invalid-method"
+	// ReasonMethodNotAllowed means EnforceMethods failed a preflight for its
+	// requested method.
+	ReasonMethodNotAllowed Reason = "method-not-allowed"
+	// ReasonTooManyHeaders means a preflight requested more headers than
+	// MaxRequestedHeaders.
+	ReasonTooManyHeaders Reason = "too-many-headers"
+	// ReasonHeaderNotAllowed means EnforceHeaders failed a preflight for one
+	// of its requested headers.
+	ReasonHeaderNotAllowed Reason = "header-not-allowed"
+	// ReasonEmptyRequestHeaders means EnforceHeaders failed a preflight whose
+	// Access-Control-Request-Headers is present but lists no header.
+	ReasonEmptyRequestHeaders Reason = "empty-request-headers"
+)
+
+// Decision is the outcome of matching a request's Origin against the Options.
+type Decision struct {
+	// Value is the Access-Control-Allow-Origin value to return. It is empty
+	// unless Allowed is true, except for the wildcard value returned to
+	// requests without an Origin when every origin is allowed.
+	Value string
+	// Allowed reports whether the origin is allowed.
+	Allowed bool
+	// Reason explains the decision.
+	Reason Reason
+}
+
+// AllowOrigin returns the Access-Control-Allow-Origin value for the request and
+// whether its origin is allowed. The value is the same as GetAllowOrigin's, but
+// allowed is false both for a missing Origin and for a denied one; use Decide
+// to tell them apart.
+func (o *Options) AllowOrigin(request *Request) (string, bool) {
+	d, _ := o.decide(request)
+
+	return d.Value, d.Allowed
+}
+
+// Decide returns the full Decision for the request's Origin.
+func (o *Options) Decide(request *Request) Decision {
+	d, _ := o.decide(request)
+
+	return d
+}
+
+// decide implements Decide, also returning the error recovered from a
+// panicking OriginMatcher.
+func (o *Options) decide(request *Request) (Decision, error) {
+	switch {
+	case request.origin() == "":
+		value, err := o.allowOrigin(request)
+
+		return Decision{Value: value, Reason: ReasonNoOrigin}, err
+	case o.ParanoidChecks && inconsistentRequest(request):
+		return Decision{Reason: ReasonInconsistentRequest}, nil
+	case o.oversizedOrigin(request):
+		return Decision{Reason: ReasonOversizedOrigin}, nil
+	case o.rejectsInsecureOrigin(request):
+		return Decision{Reason: ReasonInsecureOrigin}, nil
+	}
+
+	value, err := o.allowOrigin(request)
+
+	switch {
+	case err != nil:
+		return Decision{Reason: ReasonMatcherPanic}, err
+	case value == "":
+		return Decision{Reason: ReasonNotAllowed}, nil
+	case !isEchoSafe(value):
+		return Decision{Reason: ReasonUnsafeOrigin}, nil
+	}
+
+	return Decision{Value: value, Allowed: true, Reason: ReasonAllowed}, nil
+}
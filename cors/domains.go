@@ -0,0 +1,86 @@
+package cors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// publicSuffixes lists well known public suffixes under which anyone can
+// register a domain, so they cannot be used as an AllowDomains apex. Single
+// label top-level domains are always refused.
+var publicSuffixes = map[string]struct{}{
+	"ac.uk": {}, "co.uk": {}, "gov.uk": {}, "org.uk": {}, "me.uk": {},
+	"com.au": {}, "net.au": {}, "org.au": {}, "co.nz": {}, "co.jp": {},
+	"com.br": {}, "com.cn": {}, "co.in": {}, "co.za": {}, "com.mx": {},
+	"appspot.com": {}, "azurewebsites.net": {}, "blogspot.com": {},
+	"cloudfront.net": {}, "fly.dev": {}, "github.io": {}, "gitlab.io": {},
+	"herokuapp.com": {}, "netlify.app": {}, "pages.dev": {}, "vercel.app": {},
+	"web.app": {}, "workers.dev": {},
+}
+
+// validateDomain reports why domain cannot be used as an AllowDomains apex.
+func validateDomain(domain string) error {
+	d := normalizeDomain(domain)
+
+	switch {
+	case d == "" || strings.ContainsAny(d, "*:/ "):
+		return fmt.Errorf("invalid allowed domain %q: must be a domain name such as example.com", domain)
+	case !strings.Contains(d, "."):
+		return fmt.Errorf("invalid allowed domain %q: top-level domains cannot be allowed", domain)
+	}
+
+	if _, ok := publicSuffixes[d]; ok {
+		return fmt.Errorf("invalid allowed domain %q: public suffixes cannot be allowed", domain)
+	}
+
+	return nil
+}
+
+// normalizeDomain lower-cases domain and removes its trailing dot.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// normalizeDomains returns the valid AllowDomains entries, normalized.
+func normalizeDomains(domains []string) []string {
+	result := make([]string, 0, len(domains))
+
+	for _, d := range domains {
+		if validateDomain(d) == nil {
+			result = append(result, normalizeDomain(d))
+		}
+	}
+
+	return result
+}
+
+// matchesDomain reports whether origin's host is one of the AllowDomains or a
+// subdomain of one, on https unless AllowDomainsAnyScheme is set. Domains
+// normalized by NewHandler are reused, otherwise they are normalized on demand.
+func (o *Options) matchesDomain(origin string) bool {
+	if len(o.AllowDomains) == 0 {
+		return false
+	}
+
+	parsed, err := ParseOrigin(origin)
+	if err != nil || parsed.IsNull {
+		return false
+	}
+
+	if parsed.Scheme != "https" && !o.AllowDomainsAnyScheme {
+		return false
+	}
+
+	domains := o.domains
+	if domains == nil {
+		domains = normalizeDomains(o.AllowDomains)
+	}
+
+	for _, d := range domains {
+		if parsed.Host == d || strings.HasSuffix(parsed.Host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,6 +3,8 @@ package cors_test
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 
 	"github.com/quintinheard/traefik-cors/cors"
 )
@@ -83,6 +85,15 @@ func ExampleOptions_GetAllowMethods() {
 	}
 }
 
+func ExampleOptions_GetAllow() {
+	o := cors.NewOptions()
+	o.AllowMethods = []string{http.MethodGet, http.MethodPut, cors.HeaderValueWildcard}
+
+	fmt.Println(o.GetAllow())
+	// Output:
+	// GET, PUT, OPTIONS
+}
+
 func ExampleOptions_GetAllowHeaders() {
 	o := cors.NewOptions()
 
@@ -138,3 +149,92 @@ func ExampleHandler_ServeHTTP() {
 
 	_ = http.ListenAndServe(":80", h)
 }
+
+func ExamplePreflightResponder() {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+
+	// wrap the default responder to add a header to every preflight response
+	o.PreflightResponder = responderFunc(func(rw http.ResponseWriter, req *cors.Request, header http.Header, status int) {
+		header.Set("Cache-Control", "no-store")
+		cors.DefaultPreflightResponder.RespondPreflight(rw, req, header, status)
+	})
+
+	_ = http.ListenAndServe(":80", o.NewHandler())
+}
+
+type responderFunc func(http.ResponseWriter, *cors.Request, http.Header, int)
+
+func (f responderFunc) RespondPreflight(rw http.ResponseWriter, req *cors.Request, header http.Header, status int) {
+	f(rw, req, header, status)
+}
+
+func ExampleOriginMatcherFunc() {
+	o := cors.NewOptions()
+	o.OriginMatchers = []cors.OriginMatcher{
+		cors.OriginMatcherFunc(func(origin string) bool {
+			return strings.HasSuffix(origin, ".example.com")
+		}),
+	}
+
+	_ = http.ListenAndServe(":80", o.NewHandler())
+}
+
+func ExampleNewRegexpMatcher() {
+	m, err := cors.NewRegexpMatcher(`https://pr-[0-9]+\.preview\.example\.com`)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(m.Match("https://pr-42.preview.example.com"))
+	// Output:
+	// true
+}
+
+func ExampleParseOrigin() {
+	o, err := cors.ParseOrigin("HTTPS://Example.com:443")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(o.Scheme, o.Host, o.Port == "")
+	fmt.Println(o)
+	// Output:
+	// https example.com true
+	// https://example.com
+}
+
+func ExampleOptions_NewMiddleware() {
+	api := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	h := cors.NewOptions().NewMiddleware(api)
+
+	_ = http.ListenAndServe(":80", h)
+}
+
+func ExampleCompile() {
+	p, err := cors.Compile("https://*.example.com")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(p.Match("https://app.example.com"), p.Match("https://example.com"))
+	// Output:
+	// true false
+}
+
+func ExampleOptions_Decide() {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+
+	req := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	fmt.Println(o.Decide((*cors.Request)(req)).Reason)
+
+	req.Header.Set(cors.HeaderOrigin, "https://evil.com")
+	fmt.Println(o.Decide((*cors.Request)(req)).Reason)
+	// Output:
+	// no-origin
+	// not-allowed
+}
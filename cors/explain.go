@@ -0,0 +1,66 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// denyPreflight terminates a denied or failed preflight request with status
+// and no CORS headers. Under ExplainDeniedPreflights, the body explains reason
+// when status allows one.
+func (o *Options) denyPreflight(rw http.ResponseWriter, r *Request, status int, reason Reason, detail string) {
+	header := make(http.Header)
+	o.setDebug(header, r, reason)
+
+	if !o.ExplainDeniedPreflights || !bodyAllowed(status) {
+		o.respondPreflight(rw, r, header, status)
+
+		return
+	}
+
+	body := string(reason) + ": " + explanation(reason, detail) + "\n"
+
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set(headerContentLength, strconv.Itoa(len(body)))
+
+	o.respondPreflight(rw, r, header, status)
+
+	_, _ = rw.Write([]byte(body))
+}
+
+// explanation describes reason in a sentence fragment, quoting detail, the
+// offending origin, method or header.
+func explanation(reason Reason, detail string) string {
+	switch reason {
+	case ReasonNotAllowed:
+		return fmt.Sprintf("origin %q is not allowed", detail)
+	case ReasonInsecureOrigin:
+		return fmt.Sprintf("origin %q is not a secure origin", detail)
+	case ReasonUnsafeOrigin:
+		return "origin cannot be echoed safely"
+	case ReasonInconsistentRequest:
+		return "request headers are inconsistent"
+	case ReasonMatcherPanic:
+		return "origin matcher failed"
+	case ReasonInvalidMethod:
+		return "requested method is not a valid method"
+	case ReasonMethodNotAllowed:
+		return fmt.Sprintf("method %q is not allowed", detail)
+	case ReasonTooManyHeaders:
+		return "too many requested headers"
+	case ReasonHeaderNotAllowed:
+		return fmt.Sprintf("header %q is not allowed", detail)
+	case ReasonEmptyRequestHeaders:
+		return "requested headers are present but empty"
+	}
+
+	return "request is not allowed"
+}
+
+// bodyAllowed reports whether a response with status may have a body.
+// See: RFC9110 § 6.4.1. Content Semantics.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,108 @@
+package cors
+
+import (
+	"net"
+	"strings"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
+)
+
+// trustsProxy reports whether the request was sent by one of the
+// TrustedProxies, so its forwarded headers may be believed. Blocks parsed by
+// NewHandler are reused, otherwise they are parsed on demand.
+func (o *Options) trustsProxy(r *Request) bool {
+	if len(o.TrustedProxies) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	proxies := o.proxies
+	if proxies == nil {
+		proxies = parseProxies(o.TrustedProxies)
+	}
+
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwarded returns the value of the named parameter (proto or host) of the
+// request as forwarded by a trusted proxy, or an empty string. The Forwarded
+// header takes precedence over its X-Forwarded-* counterpart.
+// See: RFC7239 § 4. Forwarded HTTP Header Field.
+func (o *Options) forwarded(r *Request, param, header string) string {
+	if !o.trustsProxy(r) {
+		return ""
+	}
+
+	if v := forwardedParam(r.Header.Get(HeaderForwarded), param); v != "" {
+		return v
+	}
+
+	return normalize.First(r.Header.Get(header))
+}
+
+// requestHost returns the host the client sent the request to.
+func (o *Options) requestHost(r *Request) string {
+	if host := o.forwarded(r, "host", HeaderForwardedHost); host != "" {
+		return host
+	}
+
+	return r.Host
+}
+
+// forwardedParam returns the value of param in the first element of a
+// Forwarded header, which describes the proxy closest to the client.
+func forwardedParam(header, param string) string {
+	for _, pair := range strings.Split(normalize.First(header), ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], param) {
+			return strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return ""
+}
+
+// parseProxies parses every valid IP address or CIDR block in values,
+// skipping invalid ones. Use Options.Validate to report them.
+func parseProxies(values []string) []*net.IPNet {
+	proxies := make([]*net.IPNet, 0, len(values))
+
+	for _, v := range values {
+		if n, err := parseProxy(v); err == nil {
+			proxies = append(proxies, n)
+		}
+	}
+
+	return proxies
+}
+
+// parseProxy parses a CIDR block, or a single IP address as a block of one.
+func parseProxy(value string) (*net.IPNet, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(value)
+
+	return n, err
+}
@@ -0,0 +1,122 @@
+package cors
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HostOptions selects the Options applied to a request from its Host, for a
+// single middleware serving many virtual hosts with different policies.
+type HostOptions struct {
+	// Hosts maps a host name, such as "api.example.com", or a wildcard suffix,
+	// such as "*.example.com", to its Options. Exact names are tried first,
+	// then the longest matching wildcard suffix. Ports are ignored.
+	Hosts map[string]*Options
+	// Default applies to requests for hosts not listed in Hosts. When nil,
+	// such requests are passed on without any CORS processing.
+	Default *Options
+}
+
+// Validate reports the first configuration error of the Options of any host.
+// Hosts are checked in sorted order, so the same configuration always reports
+// the same error.
+func (h *HostOptions) Validate() error {
+	for _, host := range sortedHosts(h.Hosts) {
+		if strings.Contains(strings.TrimPrefix(host, "*."), "*") || h.Hosts[host] == nil {
+			return fmt.Errorf("host %q: must be a host name or a *. wildcard suffix with options", host)
+		}
+
+		if err := h.Hosts[host].Validate(); err != nil {
+			return fmt.Errorf("host %q: %w", host, err)
+		}
+	}
+
+	if h.Default != nil {
+		return h.Default.Validate()
+	}
+
+	return nil
+}
+
+// NewMiddleware returns a http.Handler that processes CORS requests with the
+// Options selected by their Host, like Options.NewMiddleware does, then passes
+// them on to next.
+func (h *HostOptions) NewMiddleware(next http.Handler) http.Handler {
+	s := &hostSelector{
+		exact:    make(map[string]http.Handler),
+		fallback: next,
+	}
+
+	for _, host := range sortedHosts(h.Hosts) {
+		mw := h.Hosts[host].NewMiddleware(next)
+
+		if strings.HasPrefix(host, "*.") {
+			s.suffixes = append(s.suffixes, hostSuffix{suffix: strings.ToLower(host[1:]), handler: mw})
+		} else {
+			s.exact[strings.ToLower(host)] = mw
+		}
+	}
+
+	// longest suffixes first, so the most specific wildcard wins
+	sort.SliceStable(s.suffixes, func(i, j int) bool {
+		return len(s.suffixes[i].suffix) > len(s.suffixes[j].suffix)
+	})
+
+	if h.Default != nil {
+		s.fallback = h.Default.NewMiddleware(next)
+	}
+
+	return s
+}
+
+type hostSuffix struct {
+	suffix  string
+	handler http.Handler
+}
+
+type hostSelector struct {
+	exact    map[string]http.Handler
+	suffixes []hostSuffix
+	fallback http.Handler
+}
+
+// ServeHTTP implements http.Handler for the hostSelector.
+func (s *hostSelector) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.handler(req.Host).ServeHTTP(rw, req)
+}
+
+// handler returns the handler of the policy selected for host.
+func (s *hostSelector) handler(host string) http.Handler {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if h, ok := s.exact[host]; ok {
+		return h
+	}
+
+	for _, hs := range s.suffixes {
+		if strings.HasSuffix(host, hs.suffix) {
+			return hs.handler
+		}
+	}
+
+	return s.fallback
+}
+
+// sortedHosts returns the keys of hosts in sorted order.
+func sortedHosts(hosts map[string]*Options) []string {
+	keys := make([]string, 0, len(hosts))
+	for k := range hosts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,85 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+func hostPolicy(origin string) *cors.Options {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{origin}
+
+	return o
+}
+
+func TestHostOptions_NewMiddleware(t *testing.T) {
+	h := &cors.HostOptions{
+		Hosts: map[string]*cors.Options{
+			"api.example.com":        hostPolicy("https://api-client.example.com"),
+			"*.example.com":          hostPolicy("https://any.example.com"),
+			"*.internal.example.com": hostPolicy("https://internal.example.com"),
+		},
+		Default: hostPolicy("https://default.example.com"),
+	}
+	require.Nil(t, h.Validate())
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) { rw.WriteHeader(http.StatusOK) })
+	mw := h.NewMiddleware(next)
+
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"api.example.com", "https://api-client.example.com"},
+		{"API.example.com:8443", "https://api-client.example.com"},
+		{"www.example.com", "https://any.example.com"},
+		{"db.internal.example.com", "https://internal.example.com"},
+		{"example.com", "https://default.example.com"},
+		{"other.org", "https://default.example.com"},
+	}
+
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodGet, http.MethodOptions} {
+			req := httptest.NewRequest(method, "http://"+tt.host+"/", nil)
+			req.Header.Set(cors.HeaderOrigin, tt.expected)
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+			req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+			rec := httptest.NewRecorder()
+			mw.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.expected, rec.Header().Get(cors.HeaderAllowOrigin), "%s %s", method, tt.host)
+		}
+	}
+
+	h.Default = nil
+	mw = h.NewMiddleware(next)
+
+	req := httptest.NewRequest(http.MethodOptions, "http://other.org/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://default.example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+	req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+	rec := httptest.NewRecorder()
+	mw.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin))
+}
+
+func TestHostOptions_Validate(t *testing.T) {
+	invalid := hostPolicy("https//example.com")
+
+	h := &cors.HostOptions{Hosts: map[string]*cors.Options{"b.example.com": invalid, "a.example.com": invalid}}
+	require.EqualError(t, h.Validate(), `host "a.example.com": allowed origin: invalid origin: pattern "https//example.com" must be scheme://host[:port]`)
+
+	h = &cors.HostOptions{Hosts: map[string]*cors.Options{"a.*.example.com": hostPolicy("https://example.com")}}
+	require.NotNil(t, h.Validate())
+
+	h = &cors.HostOptions{Default: invalid}
+	require.NotNil(t, h.Validate())
+}
@@ -0,0 +1,121 @@
+package cors_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLifecycle_SPALogin simulates a single-page application on
+// https://app.example.com logging into https://api.example.com with cookies,
+// asserting every header a browser checks. Each assertion names the console
+// error a browser would report if it failed.
+func TestLifecycle_SPALogin(t *testing.T) {
+	const app = "https://app.example.com"
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{app, "https://admin.example.com"}
+	o.AllowCredentials = true
+	o.AllowMethods = []string{http.MethodGet, http.MethodPost}
+	o.AllowHeaders = []string{"Content-Type"}
+	o.ExposeHeaders = []string{"X-Session-Expires"}
+	require.Nil(t, o.Validate())
+
+	var backend int
+
+	api := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		backend++
+
+		switch req.URL.Path {
+		case "/login":
+			http.SetCookie(rw, &http.Cookie{
+				Name: "session", Value: "s3cr3t", Path: "/",
+				HttpOnly: true, Secure: true, SameSite: http.SameSiteNoneMode,
+			})
+			rw.Header().Set("X-Session-Expires", "3600")
+			rw.WriteHeader(http.StatusNoContent)
+		case "/me":
+			if c, err := req.Cookie("session"); err != nil || c.Value != "s3cr3t" {
+				rw.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			_, _ = rw.Write([]byte(`{"user":"example"}`))
+		}
+	})
+
+	srv := httptest.NewTLSServer(o.NewMiddleware(api))
+	defer srv.Close()
+
+	send := func(method, path string, header http.Header) *http.Response {
+		req, err := http.NewRequestWithContext(context.Background(), method, srv.URL+path, strings.NewReader(""))
+		require.Nil(t, err)
+
+		req.Header = header
+		req.Header.Set(cors.HeaderOrigin, app)
+
+		res, err := srv.Client().Do(req)
+		require.Nil(t, err)
+		require.Nil(t, res.Body.Close())
+
+		return res
+	}
+
+	// 1. fetch("/login", {method: "POST", credentials: "include",
+	//    headers: {"Content-Type": "application/json"}}) needs a preflight,
+	//    as application/json is not a CORS-safelisted Content-Type.
+	res := send(http.MethodOptions, "/login", http.Header{
+		cors.HeaderRequestMethod:  {http.MethodPost},
+		cors.HeaderRequestHeaders: {"content-type"},
+	})
+
+	// "Response to preflight request doesn't pass access control check: It does not have HTTP ok status."
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	// "The 'Access-Control-Allow-Origin' header has a value '*' ... when the request's credentials mode is 'include'."
+	require.Equal(t, app, res.Header.Get(cors.HeaderAllowOrigin))
+	// "The value of the 'Access-Control-Allow-Credentials' header in the response is '' which must be 'true'."
+	require.Equal(t, "true", res.Header.Get(cors.HeaderAllowCredentials))
+	// "Method POST is not allowed by Access-Control-Allow-Methods in preflight response."
+	require.Contains(t, res.Header.Get(cors.HeaderAllowMethods), http.MethodPost)
+	// "Request header field content-type is not allowed by Access-Control-Allow-Headers in preflight response."
+	require.Contains(t, strings.ToLower(res.Header.Get(cors.HeaderAllowHeaders)), "content-type")
+	// A shared cache must not serve this response to another origin.
+	require.Equal(t, cors.HeaderOrigin, res.Header.Get(cors.HeaderVary))
+	// The preflight is answered by the middleware alone.
+	require.Equal(t, 0, backend)
+
+	// 2. The credentialed POST itself.
+	res = send(http.MethodPost, "/login", http.Header{"Content-Type": {"application/json"}})
+
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Equal(t, 1, backend)
+	// Without these two the browser discards the response, including its cookie.
+	require.Equal(t, app, res.Header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "true", res.Header.Get(cors.HeaderAllowCredentials))
+	// response.headers.get("X-Session-Expires") returns null unless exposed.
+	require.Equal(t, "X-Session-Expires", res.Header.Get(cors.HeaderExposeHeaders))
+	require.Equal(t, cors.HeaderOrigin, res.Header.Get(cors.HeaderVary))
+
+	// "This Set-Cookie was blocked because it had the SameSite=Lax attribute
+	// but came from a cross-site response": CORS cannot fix this, the backend
+	// must send SameSite=None and Secure.
+	cookies := res.Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, http.SameSiteNoneMode, cookies[0].SameSite)
+	require.True(t, cookies[0].Secure)
+
+	// 3. A credentialed GET is a simple request: no preflight, the cookie is sent.
+	res = send(http.MethodGet, "/me", http.Header{"Cookie": {cookies[0].Name + "=" + cookies[0].Value}})
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, 2, backend)
+	require.Equal(t, app, res.Header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "true", res.Header.Get(cors.HeaderAllowCredentials))
+	require.Equal(t, cors.HeaderOrigin, res.Header.Get(cors.HeaderVary))
+}
@@ -0,0 +1,84 @@
+package cors
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	c := newLRU(2)
+
+	c.add("a", true)
+	c.add("b", false)
+
+	v, ok := c.get("a")
+	require.True(t, ok)
+	require.Equal(t, true, v)
+
+	// "b" is now the least recently used entry
+	c.add("c", true)
+
+	_, ok = c.get("b")
+	require.False(t, ok)
+
+	_, ok = c.get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, c.len())
+
+	c.add("a", false)
+
+	v, _ = c.get("a")
+	require.Equal(t, false, v)
+	require.Equal(t, 2, c.len())
+}
+
+func TestLRU_Concurrent(t *testing.T) {
+	c := newLRU(16)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa((i * j) % 32)
+				c.add(key, j)
+				c.get(key)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	require.LessOrEqual(t, c.len(), 16)
+}
+
+func TestOptions_OriginCache(t *testing.T) {
+	o := NewOptions()
+	o.AllowOrigins = []string{"https://example.com", "https://*.example.org"}
+	o.NewHandler()
+
+	require.NotNil(t, o.matched)
+	require.True(t, o.matchesCompiled("https://example.com"))
+	require.Equal(t, 0, o.matched.len(), "literal origins are not cached")
+
+	require.True(t, o.matchesCompiled("https://a.example.org"))
+	require.False(t, o.matchesCompiled("https://a.example.net"))
+	require.Equal(t, 2, o.matched.len())
+
+	// rebuilding the handler starts from an empty cache
+	o.AllowOrigins = []string{"https://*.example.net"}
+	o.NewHandler()
+	require.Equal(t, 0, o.matched.len())
+	require.True(t, o.matchesCompiled("https://a.example.net"))
+
+	o.OriginCacheSize = 0
+	o.NewHandler()
+	require.Nil(t, o.matched)
+	require.True(t, o.matchesCompiled("https://a.example.net"))
+}
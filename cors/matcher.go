@@ -0,0 +1,58 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrMatcherPanic is wrapped by the error reported when an OriginMatcher panics.
+var ErrMatcherPanic = errors.New("origin matcher panicked")
+
+// OriginMatcher decides whether an origin is allowed. Implementations must be
+// safe for concurrent use, since a handler may call Match from many requests
+// at once.
+type OriginMatcher interface {
+	Match(origin string) bool
+}
+
+// OriginMatcherFunc adapts a function to the OriginMatcher interface.
+type OriginMatcherFunc func(origin string) bool
+
+// Match implements OriginMatcher.
+func (f OriginMatcherFunc) Match(origin string) bool {
+	return f(origin)
+}
+
+// RegexpMatcher is an OriginMatcher allowing origins that fully match a
+// regular expression.
+type RegexpMatcher struct {
+	re *regexp.Regexp
+}
+
+// NewRegexpMatcher compiles expr into a RegexpMatcher. The expression is
+// anchored, so it must match the whole origin.
+func NewRegexpMatcher(expr string) (*RegexpMatcher, error) {
+	re, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegexpMatcher{re: re}, nil
+}
+
+// Match implements OriginMatcher.
+func (m *RegexpMatcher) Match(origin string) bool {
+	return m.re.MatchString(origin)
+}
+
+// safeMatch calls m.Match, converting a panic into an error.
+func safeMatch(m OriginMatcher, origin string) (matched bool, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			matched, err = false, fmt.Errorf("%w: %v", ErrMatcherPanic, v)
+		}
+	}()
+
+	return m.Match(origin), nil
+}
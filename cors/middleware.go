@@ -0,0 +1,387 @@
+package cors
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NewMiddleware returns a http.Handler that processes CORS requests like the
+// handler returned by NewHandler, then passes the request on to next unless
+// the response was already terminated, as it is for preflight requests. The
+// request passed on has no Origin header when StripOriginHeader is set, and
+// carries it in ForwardOriginHeader when ForwardOrigin is set. Successful
+// preflight requests are passed on too when PassthroughPreflight is set, and
+// other OPTIONS requests are answered with an Allow header unless
+// OptionsPassthrough is set. Under HeaderPhaseResponse, the CORS headers of
+// requests passed on are only applied once next writes its response.
+func (o *Options) NewMiddleware(next http.Handler) http.Handler {
+	return &middleware{
+		handler: o.NewHandler().(*handler),
+		next:    next,
+	}
+}
+
+type middleware struct {
+	handler *handler
+	next    http.Handler
+}
+
+// ServeHTTP implements http.Handler for the middleware.
+func (m *middleware) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	o := (*Options)(m.handler)
+
+	var deferred http.Header
+
+	if o.HeaderPhase == HeaderPhaseResponse {
+		pw := &phaseWriter{ResponseWriter: rw, options: o, header: make(http.Header)}
+		if m.handler.serve(pw, req) {
+			return
+		}
+
+		deferred = pw.header
+	} else if m.handler.serve(rw, req) {
+		return
+	}
+
+	preflight := o.isPreflight((*Request)(req))
+
+	if !o.OptionsPassthrough && req.Method == http.MethodOptions && !preflight {
+		o.applyHeaders(rw.Header(), deferred)
+		rw.Header().Set(HeaderAllow, o.cache[HeaderAllow])
+		rw.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	var w *backendWriter
+
+	if header := rw.Header(); backendCORS(o, preflight) || len(header[HeaderVary]) > 0 ||
+		len(header[HeaderAllowOrigin]) > 0 || len(deferred) > 0 || o.StripUpstreamCORSHeaders && deferred != nil {
+		w = newBackendWriter(o, rw, backendCORS(o, preflight))
+		w.deferred = deferred
+		rw = w
+
+		// A panic recovered further up the chain is answered through the
+		// writer the middleware was given, so the headers are settled first.
+		defer func() {
+			if v := recover(); v != nil {
+				w.settle()
+				panic(v)
+			}
+		}()
+	}
+
+	if m.handler.StripOriginHeader || m.handler.ForwardOrigin {
+		req = m.handler.forwardedRequest(req)
+	}
+
+	m.next.ServeHTTP(rw, req)
+
+	// A backend that writes nothing gets its response written by the server,
+	// through the writer the middleware was given.
+	if w != nil {
+		w.settle()
+	}
+}
+
+// backendCORS reports whether the CORS headers of the backend take precedence
+// over those of the middleware, under PassthroughPreflight or
+// PreserveExistingHeaders.
+func backendCORS(o *Options, preflight bool) bool {
+	return o.PassthroughPreflight && preflight || o.PreserveExistingHeaders
+}
+
+// backendWriter passes a response on to the backend, and settles its headers
+// when it is written, whatever its status. The Vary values written before the
+// backend ran are merged with those of the backend into a single field, so a
+// name the backend adds again is listed once and one the backend drops is
+// kept. Access-Control-Allow-Origin and Access-Control-Allow-Credentials are
+// restored if the backend removed or changed them, so browsers can read error
+// responses too. When the CORS headers of the backend take precedence, the
+// values the backend appended to a CORS header written by the middleware
+// replace those of the middleware instead, so the header is never duplicated.
+// Under HeaderPhaseResponse, the Access-Control-* headers of the backend are
+// first deleted under StripUpstreamCORSHeaders, and the deferred headers of
+// the middleware are then applied.
+type backendWriter struct {
+	http.ResponseWriter
+	options     *Options
+	vary        []string
+	origin      []string
+	credentials []string
+	written     map[string][]string
+	deferred    http.Header
+	done        bool
+}
+
+func newBackendWriter(o *Options, rw http.ResponseWriter, cors bool) *backendWriter {
+	header := rw.Header()
+	w := &backendWriter{
+		ResponseWriter: rw,
+		options:        o,
+		vary:           header[HeaderVary],
+		origin:         header[HeaderAllowOrigin],
+		credentials:    header[HeaderAllowCredentials],
+	}
+
+	if !cors {
+		return w
+	}
+
+	w.written = make(map[string][]string)
+
+	for k, v := range header {
+		if strings.HasPrefix(k, "Access-Control-") {
+			w.written[k] = v
+		}
+	}
+
+	return w
+}
+
+// WriteHeader implements http.ResponseWriter. Informational responses, such
+// as 103 Early Hints, are passed on as is: the headers are only settled for
+// the final response.
+func (w *backendWriter) WriteHeader(status int) {
+	if !informational(status) {
+		w.settle()
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// informational reports whether status is that of an informational response,
+// which precedes the final response. 101 Switching Protocols is final.
+// See: RFC9110 § 15.2. Informational 1xx.
+func informational(status int) bool {
+	return status >= 100 && status <= 199 && status != http.StatusSwitchingProtocols
+}
+
+// Write implements http.ResponseWriter.
+func (w *backendWriter) Write(b []byte) (int, error) {
+	w.settle()
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher when the underlying writer does.
+func (w *backendWriter) Flush() {
+	w.settle()
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker when the underlying writer does, so
+// protocol upgrades such as WebSocket go through the middleware.
+func (w *backendWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.settle()
+
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errNotHijacker
+	}
+
+	return h.Hijack()
+}
+
+var errNotHijacker = errors.New("response writer does not implement http.Hijacker")
+
+// Push implements http.Pusher when the underlying writer does.
+func (w *backendWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// ReadFrom implements io.ReaderFrom, so the underlying writer can use sendfile
+// when it does.
+func (w *backendWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.settle()
+
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+
+	return io.Copy(writerOnly{w.ResponseWriter}, r)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *backendWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writerOnly hides the io.ReaderFrom of a writer from io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// settle merges the Vary header, drops the values written by the middleware
+// from the CORS headers the backend appended to and restores the allowed
+// origin and credentials, once.
+func (w *backendWriter) settle() {
+	if w.done {
+		return
+	}
+
+	w.done = true
+	header := w.Header()
+
+	if w.options.StripUpstreamCORSHeaders {
+		w.strip(header)
+	}
+
+	if len(w.vary) > 0 {
+		w.options.addVary(header, w.vary...)
+	}
+
+	for k, ours := range w.written {
+		if v := header[k]; len(v) > len(ours) && sameValues(v[:len(ours)], ours) {
+			header[k] = v[len(ours):]
+		}
+	}
+
+	w.restore(header, HeaderAllowOrigin, w.origin)
+	w.restore(header, HeaderAllowCredentials, w.credentials)
+	w.options.applyHeaders(header, w.deferred)
+}
+
+// strip deletes the Access-Control-* headers of header, or only those the
+// middleware writes under StripManagedHeadersOnly.
+func (w *backendWriter) strip(header http.Header) {
+	for k := range header {
+		if !strings.HasPrefix(k, "Access-Control-") {
+			continue
+		}
+
+		if _, ok := w.deferred[k]; ok || !w.options.StripManagedHeadersOnly {
+			delete(header, k)
+		}
+	}
+}
+
+// restore sets the header name back to the values written before the backend
+// ran, unless the backend's values take precedence and it kept the header.
+func (w *backendWriter) restore(header http.Header, name string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	if _, ok := header[name]; ok && w.written != nil {
+		return
+	}
+
+	header[name] = values
+}
+
+// phaseWriter collects the headers the handler writes under
+// HeaderPhaseResponse, so they can be applied once the backend writes its
+// response. Responses the handler terminates, such as preflight responses,
+// get them at once.
+type phaseWriter struct {
+	http.ResponseWriter
+	options *Options
+	header  http.Header
+	applied bool
+}
+
+// Header implements http.ResponseWriter.
+func (w *phaseWriter) Header() http.Header {
+	if w.applied {
+		return w.ResponseWriter.Header()
+	}
+
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *phaseWriter) WriteHeader(status int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (w *phaseWriter) Write(b []byte) (int, error) {
+	w.apply()
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *phaseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *phaseWriter) apply() {
+	if !w.applied {
+		w.applied = true
+		w.options.applyHeaders(w.ResponseWriter.Header(), w.header)
+	}
+}
+
+// applyHeaders sets the headers of src on dst, merging Vary and keeping the
+// headers dst already has under PreserveExistingHeaders.
+func (o *Options) applyHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if k == HeaderVary {
+			o.addVary(dst, v...)
+
+			continue
+		}
+
+		if _, ok := dst[k]; ok && o.PreserveExistingHeaders {
+			continue
+		}
+
+		dst[k] = v
+	}
+}
+
+func sameValues(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// forwardedRequest returns a copy of req, as passed on to the next handler
+// under StripOriginHeader and ForwardOrigin.
+func (h *handler) forwardedRequest(req *http.Request) *http.Request {
+	origin := (*Request)(req).origin()
+
+	req = req.Clone(req.Context())
+
+	if h.ForwardOrigin {
+		header := h.ForwardOriginHeader
+		if header == "" {
+			header = DefaultForwardOriginHeader
+		}
+
+		req.Header.Del(header)
+
+		if origin != "" {
+			req.Header.Set(header, origin)
+		}
+	}
+
+	if h.StripOriginHeader {
+		req.Header.Del(HeaderOrigin)
+
+		if h.StripRequestHeaders {
+			req.Header.Del(HeaderRequestMethod)
+			req.Header.Del(HeaderRequestHeaders)
+		}
+	}
+
+	return req
+}
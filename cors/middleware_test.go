@@ -0,0 +1,169 @@
+package cors_test
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+// newServer serves backend behind the middleware of Options allowing
+// https://example.com, which wraps the response writer of allowed requests.
+func newServer(t *testing.T, phase string, backend http.HandlerFunc) *httptest.Server {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.HeaderPhase = phase
+
+	srv := httptest.NewServer(o.NewMiddleware(backend))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestMiddleware_Flush(t *testing.T) {
+	for _, phase := range []string{cors.HeaderPhaseRequest, cors.HeaderPhaseResponse} {
+		release := make(chan struct{})
+
+		srv := newServer(t, phase, func(rw http.ResponseWriter, _ *http.Request) {
+			rw.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(rw, "data: first\n\n")
+			rw.(http.Flusher).Flush()
+
+			// the second event is only sent once the first one was read
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+
+			_, _ = io.WriteString(rw, "data: second\n\n")
+		})
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err, phase)
+		require.Equal(t, "https://example.com", res.Header.Get(cors.HeaderAllowOrigin), phase)
+
+		line, err := bufio.NewReader(res.Body).ReadString('\n')
+		require.NoError(t, err, phase)
+		require.Equal(t, "data: first\n", line, phase)
+
+		close(release)
+		require.NoError(t, res.Body.Close())
+	}
+}
+
+func TestMiddleware_Hijack(t *testing.T) {
+	for _, phase := range []string{cors.HeaderPhaseRequest, cors.HeaderPhaseResponse} {
+		srv := newServer(t, phase, func(rw http.ResponseWriter, _ *http.Request) {
+			conn, buf, err := rw.(http.Hijacker).Hijack()
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+
+				return
+			}
+			defer conn.Close()
+
+			_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\nhello")
+			_ = buf.Flush()
+		})
+
+		conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+		require.NoError(t, err, phase)
+
+		_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: cors.example.com\r\nOrigin: https://example.com\r\n"+
+			"Connection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		require.NoError(t, err, phase)
+
+		r := bufio.NewReader(conn)
+
+		res, err := http.ReadResponse(r, nil)
+		require.NoError(t, err, phase)
+		require.Equal(t, http.StatusSwitchingProtocols, res.StatusCode, phase)
+
+		// the upgraded connection carries the rest
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err, phase)
+		require.Equal(t, "hello", string(data), phase)
+		require.NoError(t, conn.Close())
+	}
+}
+
+func TestMiddleware_OptionalInterfaces(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.HeaderPhase = cors.HeaderPhaseResponse
+
+	var (
+		pushErr error
+		copied  int64
+	)
+
+	h := o.NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		pushErr = rw.(http.Pusher).Push("/app.js", nil)
+		copied, _ = rw.(io.ReaderFrom).ReadFrom(strings.NewReader("body"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.ErrNotSupported, pushErr)
+	require.Equal(t, int64(4), copied)
+	require.Equal(t, "body", rec.Body.String())
+	require.Equal(t, "https://example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+}
+
+func TestMiddleware_EarlyHints(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.HeaderPhase = cors.HeaderPhaseResponse
+	o.StripUpstreamCORSHeaders = true
+
+	srv := httptest.NewServer(o.NewMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Link", "</app.css>; rel=preload; as=style")
+		rw.WriteHeader(http.StatusEarlyHints)
+
+		rw.Header().Set(cors.HeaderAllowOrigin, "https://evil.example.com")
+		rw.WriteHeader(http.StatusOK)
+	})))
+	t.Cleanup(srv.Close)
+
+	var hints []int
+
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, _ textproto.MIMEHeader) error {
+			hints = append(hints, code)
+
+			return nil
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+
+	// the final response is settled, not the early hints
+	require.Equal(t, []int{http.StatusEarlyHints}, hints)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, []string{"https://example.com"}, res.Header.Values(cors.HeaderAllowOrigin))
+}
@@ -0,0 +1,228 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// originNull is the serialization of an opaque origin.
+// See: HTML Standard § 7.1.1 Origins.
+const originNull = "null"
+
+// ErrInvalidOrigin is returned, wrapped, by ParseOrigin for values that are
+// not a serialized origin.
+var ErrInvalidOrigin = errors.New("invalid origin")
+
+// Origin is a parsed serialized origin, as sent in the Origin request header.
+// See: RFC6454 § 6.2. ASCII Serialization of an Origin
+type Origin struct {
+	// Scheme is the lower case scheme, such as "https".
+	Scheme string
+	// Host is the host name or IP literal, without brackets. It is lower
+	// cased for schemes whose hosts are domains, and kept verbatim otherwise
+	// (for example the ID in chrome-extension://<id>).
+	Host string
+	// Port is the explicit port, or empty when the origin uses the default
+	// port of its scheme.
+	Port string
+	// IsNull reports whether the origin is the opaque origin "null", in which
+	// case all other fields are empty.
+	IsNull bool
+}
+
+// defaultPorts maps the schemes with a known default port to that port.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+// ParseOrigin parses a serialized origin such as "https://example.com:8443"
+// or "null". Values containing whitespace, control characters, user info, a
+// path, a query or a fragment are refused.
+func ParseOrigin(s string) (Origin, error) {
+	if s == originNull {
+		return Origin{IsNull: true}, nil
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] == 0x7f {
+			return Origin{}, fmt.Errorf("%w: %q contains whitespace or control characters", ErrInvalidOrigin, s)
+		}
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return Origin{}, fmt.Errorf("%w: %q: %v", ErrInvalidOrigin, s, err)
+	}
+
+	switch {
+	case u.Scheme == "" || u.Host == "" || u.Opaque != "":
+		return Origin{}, fmt.Errorf("%w: %q must be scheme://host[:port]", ErrInvalidOrigin, s)
+	case u.User != nil:
+		return Origin{}, fmt.Errorf("%w: %q contains user info", ErrInvalidOrigin, s)
+	case u.Path != "" || u.RawPath != "":
+		return Origin{}, fmt.Errorf("%w: %q contains a path", ErrInvalidOrigin, s)
+	case u.RawQuery != "" || u.ForceQuery || strings.Contains(s, "#"):
+		return Origin{}, fmt.Errorf("%w: %q contains a query or fragment", ErrInvalidOrigin, s)
+	}
+
+	o := Origin{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   u.Hostname(),
+		Port:   u.Port(),
+	}
+
+	if _, ok := defaultPorts[o.Scheme]; ok {
+		o.Host = strings.ToLower(o.Host)
+	}
+
+	if o.Port != "" && defaultPorts[o.Scheme] == o.Port {
+		o.Port = ""
+	}
+
+	return o, nil
+}
+
+// String returns the canonical serialization of the origin.
+func (o Origin) String() string {
+	if o.IsNull {
+		return originNull
+	}
+
+	host := o.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	if o.Port != "" {
+		host += ":" + o.Port
+	}
+
+	return o.Scheme + "://" + host
+}
+
+// IsLoopback reports whether the origin's host is a loopback host: localhost,
+// a subdomain of localhost, or a loopback IP address.
+func (o Origin) IsLoopback() bool {
+	if o.Host == "localhost" || strings.HasSuffix(o.Host, ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(o.Host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// extensionSchemes are the schemes browsers use for extension origins.
+var extensionSchemes = []string{"chrome-extension", "moz-extension", "safari-web-extension"}
+
+// extensionOrigins expands extension IDs to their origin in every browser.
+func extensionOrigins(ids []string) []string {
+	origins := make([]string, 0, len(ids)*len(extensionSchemes))
+
+	for _, id := range ids {
+		for _, scheme := range extensionSchemes {
+			origins = append(origins, scheme+"://"+id)
+		}
+	}
+
+	return origins
+}
+
+// isExtensionID reports whether id only holds the letters, digits and dashes
+// used by Chrome extension IDs and Firefox or Safari extension UUIDs.
+func isExtensionID(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isEchoSafe reports whether value may be written to a response header as is.
+// Values holding control characters, whitespace or commas are refused, so a
+// hostile Origin accepted by a lenient OriginMatcher cannot split the response
+// or smuggle a list into Access-Control-Allow-Origin.
+func isEchoSafe(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c <= ' ' || c == ',' || c == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSameOrigin reports whether the request's Origin header equals the origin
+// the request was sent to. The scheme is SelfScheme when set, otherwise it is
+// forwarded by a trusted proxy or taken from the TLS state, and the host is
+// forwarded by a trusted proxy or taken from the Host header. Default ports
+// are ignored.
+func (o *Options) isSameOrigin(r *Request) bool {
+	origin, err := ParseOrigin(r.origin())
+	if err != nil || origin.IsNull {
+		return false
+	}
+
+	self, err := ParseOrigin(o.requestScheme(r) + "://" + o.requestHost(r))
+	if err != nil {
+		return false
+	}
+
+	return origin == self
+}
+
+// requestScheme returns the scheme the client used to send the request.
+func (o *Options) requestScheme(r *Request) string {
+	if o.SelfScheme != "" {
+		return o.SelfScheme
+	}
+
+	if proto := o.forwarded(r, "proto", HeaderForwardedProto); proto != "" {
+		return proto
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// oversizedOrigin reports whether the request's Origin exceeds MaxOriginLength.
+func (o *Options) oversizedOrigin(r *Request) bool {
+	return o.MaxOriginLength > 0 && len(r.Header.Get(HeaderOrigin)) > o.MaxOriginLength
+}
+
+// rejectsInsecureOrigin reports whether RequireSecureOrigins rejects the
+// request's Origin, that is an Origin present but neither https nor loopback.
+// Origins that cannot be parsed, including "null", are rejected.
+func (o *Options) rejectsInsecureOrigin(r *Request) bool {
+	if !o.RequireSecureOrigins {
+		return false
+	}
+
+	origin := r.origin()
+	if origin == "" {
+		return false
+	}
+
+	parsed, err := ParseOrigin(origin)
+	if err != nil || parsed.IsNull {
+		return true
+	}
+
+	return parsed.Scheme != "https" && !parsed.IsLoopback()
+}
@@ -0,0 +1,91 @@
+package cors
+
+import (
+	"strings"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
+)
+
+// Headers inspected by ParanoidChecks. Content-Length is also set on preflight
+// responses.
+const (
+	headerSecFetchSite     = "Sec-Fetch-Site"
+	headerContentLength    = "Content-Length"
+	headerTransferEncoding = "Transfer-Encoding"
+)
+
+// paranoidChecks are the consistency checks run when ParanoidChecks is set.
+// Each reports whether the request shows an inconsistency that makes its
+// Origin untrustworthy.
+var paranoidChecks = []func(r *Request) bool{
+	conflictingOrigins,
+	combinedOrigin,
+	originWithoutInitiator,
+	ambiguousBodyLength,
+}
+
+// inconsistentRequest reports whether any of the paranoidChecks fails.
+func inconsistentRequest(r *Request) bool {
+	for _, check := range paranoidChecks {
+		if check(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// conflictingOrigins reports whether the request has several Origin headers
+// with different values.
+func conflictingOrigins(r *Request) bool {
+	values := r.Header.Values(HeaderOrigin)
+
+	for _, v := range values {
+		if v != values[0] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// combinedOrigin reports whether the Origin looks like several Origin headers
+// combined into one list by an intermediary. A serialized origin never holds
+// a comma.
+func combinedOrigin(r *Request) bool {
+	return strings.Contains(r.Header.Get(HeaderOrigin), ",")
+}
+
+// originWithoutInitiator reports whether a request marked by the browser as
+// user initiated, with Sec-Fetch-Site: none, carries an Origin other than
+// "null". Such requests are never cross-origin.
+// See: Fetch Metadata Request Headers § 2.1. The Sec-Fetch-Site HTTP Request Header.
+func originWithoutInitiator(r *Request) bool {
+	if !strings.EqualFold(normalize.TrimOWS(r.Header.Get(headerSecFetchSite)), "none") {
+		return false
+	}
+
+	origin := r.origin()
+
+	return origin != "" && origin != originNull
+}
+
+// ambiguousBodyLength reports whether the request framing is ambiguous: a
+// Content-Length alongside a Transfer-Encoding, or several Content-Length
+// values that disagree.
+// See: RFC7230 § 3.3.3. Message Body Length.
+func ambiguousBodyLength(r *Request) bool {
+	lengths := r.Header.Values(headerContentLength)
+
+	if len(lengths) > 0 && (len(r.TransferEncoding) > 0 || r.Header.Get(headerTransferEncoding) != "") {
+		return true
+	}
+
+	for _, l := range lengths {
+		if strings.Contains(l, ",") || l != lengths[0] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func paranoidRequest(header http.Header) *Request {
+	req := httptest.NewRequest(http.MethodPost, "https://cors.example.com/api/", nil)
+	req.Header = header
+
+	return (*Request)(req)
+}
+
+func TestConflictingOrigins(t *testing.T) {
+	tests := map[string]struct {
+		header   http.Header
+		expected bool
+	}{
+		"none":      {http.Header{}, false},
+		"single":    {http.Header{"Origin": {"https://a.com"}}, false},
+		"duplicate": {http.Header{"Origin": {"https://a.com", "https://a.com"}}, false},
+		"different": {http.Header{"Origin": {"https://a.com", "https://b.com"}}, true},
+	}
+
+	for name, tt := range tests {
+		require.Equal(t, tt.expected, conflictingOrigins(paranoidRequest(tt.header)), name)
+	}
+}
+
+func TestCombinedOrigin(t *testing.T) {
+	require.False(t, combinedOrigin(paranoidRequest(http.Header{"Origin": {"https://a.com"}})))
+	require.True(t, combinedOrigin(paranoidRequest(http.Header{"Origin": {"https://a.com, https://b.com"}})))
+}
+
+func TestOriginWithoutInitiator(t *testing.T) {
+	tests := map[string]struct {
+		header   http.Header
+		expected bool
+	}{
+		"no fetch metadata": {http.Header{"Origin": {"https://a.com"}}, false},
+		"cross-site":        {http.Header{"Origin": {"https://a.com"}, "Sec-Fetch-Site": {"cross-site"}}, false},
+		"none with origin":  {http.Header{"Origin": {"https://a.com"}, "Sec-Fetch-Site": {"None"}}, true},
+		"none with null":    {http.Header{"Origin": {"null"}, "Sec-Fetch-Site": {"none"}}, false},
+		"none alone":        {http.Header{"Sec-Fetch-Site": {"none"}}, false},
+	}
+
+	for name, tt := range tests {
+		require.Equal(t, tt.expected, originWithoutInitiator(paranoidRequest(tt.header)), name)
+	}
+}
+
+func TestAmbiguousBodyLength(t *testing.T) {
+	tests := map[string]struct {
+		header   http.Header
+		expected bool
+	}{
+		"none":               {http.Header{}, false},
+		"content length":     {http.Header{"Content-Length": {"5"}}, false},
+		"transfer encoding":  {http.Header{"Transfer-Encoding": {"chunked"}}, false},
+		"both":               {http.Header{"Content-Length": {"5"}, "Transfer-Encoding": {"chunked"}}, true},
+		"repeated length":    {http.Header{"Content-Length": {"5", "5"}}, false},
+		"conflicting length": {http.Header{"Content-Length": {"5", "6"}}, true},
+		"combined length":    {http.Header{"Content-Length": {"5, 6"}}, true},
+	}
+
+	for name, tt := range tests {
+		require.Equal(t, tt.expected, ambiguousBodyLength(paranoidRequest(tt.header)), name)
+	}
+
+	req := paranoidRequest(http.Header{"Content-Length": {"5"}})
+	req.TransferEncoding = []string{"chunked"}
+	require.True(t, ambiguousBodyLength(req))
+}
+
+func TestOptions_Decide_ParanoidChecks(t *testing.T) {
+	o := NewOptions()
+	o.AllowOrigins = []string{"https://a.com"}
+	o.NewHandler()
+
+	req := paranoidRequest(http.Header{"Origin": {"https://a.com"}, "Sec-Fetch-Site": {"none"}})
+	require.Equal(t, ReasonAllowed, o.Decide(req).Reason)
+
+	o.ParanoidChecks = true
+	require.Equal(t, Decision{Reason: ReasonInconsistentRequest}, o.Decide(req))
+	require.Empty(t, o.GetAllowOrigin(req))
+
+	req = paranoidRequest(http.Header{"Origin": {"https://a.com"}, "Sec-Fetch-Site": {"cross-site"}})
+	require.Equal(t, ReasonAllowed, o.Decide(req).Reason)
+}
@@ -0,0 +1,151 @@
+package cors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pattern is a compiled AllowOrigins entry. Besides literal origins, a pattern
+// may use a "*." prefix on the host to match any subdomain (at any depth) and
+// a ":*" port to match any port, as in "https://*.example.com:*".
+type Pattern struct {
+	raw       string
+	scheme    string
+	host      string
+	port      string
+	subdomain bool
+	anyPort   bool
+}
+
+// Compile parses an origin pattern. Invalid patterns, such as
+// "https//example.com", "https://example.com/path" or "https://ex*mple.com",
+// are reported with an error wrapping ErrInvalidOrigin. So are subdomain
+// wildcards of a top-level domain or a public suffix, such as "https://*.com"
+// or "https://*.github.io", which would allow any origin an attacker can
+// register there; "*.localhost" is allowed, as it never leaves the machine.
+func Compile(s string) (Pattern, error) {
+	return compile(s, false)
+}
+
+// compile parses an origin pattern like Compile does, allowing subdomain
+// wildcards of a top-level domain or a public suffix when allowPublic is set.
+func compile(s string, allowPublic bool) (Pattern, error) {
+	p := Pattern{raw: s}
+
+	i := strings.Index(s, "://")
+	if i <= 0 {
+		return Pattern{}, fmt.Errorf("%w: pattern %q must be scheme://host[:port]", ErrInvalidOrigin, s)
+	}
+
+	rest := s[i+3:]
+
+	if strings.HasPrefix(rest, "*.") {
+		p.subdomain = true
+		rest = "wildcard" + rest[1:]
+	}
+
+	if strings.HasSuffix(rest, ":*") {
+		p.anyPort = true
+		rest = strings.TrimSuffix(rest, ":*")
+	}
+
+	if strings.Contains(rest, "*") {
+		return Pattern{}, fmt.Errorf("%w: pattern %q may only use *. as a host prefix and :* as a port", ErrInvalidOrigin, s)
+	}
+
+	o, err := ParseOrigin(s[:i+3] + rest)
+	if err != nil {
+		return Pattern{}, err
+	}
+
+	if o.IsNull {
+		return Pattern{}, fmt.Errorf("%w: the null origin cannot be allowed", ErrInvalidOrigin)
+	}
+
+	p.scheme = o.Scheme
+	p.host = o.Host
+	p.port = o.Port
+
+	if p.subdomain {
+		p.host = strings.TrimPrefix(p.host, "wildcard")
+	}
+
+	if p.subdomain && !allowPublic {
+		suffix := normalizeDomain(p.host[1:])
+
+		if _, ok := publicSuffixes[suffix]; ok {
+			return Pattern{}, fmt.Errorf("%w: pattern %q allows every domain of a public suffix", ErrInvalidOrigin, s)
+		}
+
+		if suffix != "localhost" && !strings.Contains(suffix, ".") {
+			return Pattern{}, fmt.Errorf("%w: pattern %q allows every domain of a top-level domain", ErrInvalidOrigin, s)
+		}
+	}
+
+	return p, nil
+}
+
+// compile parses an origin pattern, honoring AllowPublicSuffixPatterns.
+func (o *Options) compile(s string) (Pattern, error) {
+	return compile(s, o.AllowPublicSuffixPatterns)
+}
+
+// IsLiteral reports whether the pattern only matches a single origin.
+func (p Pattern) IsLiteral() bool {
+	return !p.subdomain && !p.anyPort
+}
+
+// String returns the pattern as it was compiled.
+func (p Pattern) String() string {
+	return p.raw
+}
+
+// Match reports whether origin matches the pattern. Pattern implements
+// OriginMatcher.
+func (p Pattern) Match(origin string) bool {
+	o, err := ParseOrigin(origin)
+
+	return err == nil && p.matchOrigin(o)
+}
+
+// matchOrigin reports whether the parsed origin o matches the pattern.
+func (p Pattern) matchOrigin(o Origin) bool {
+	if o.IsNull || o.Scheme != p.scheme {
+		return false
+	}
+
+	if !p.anyPort && o.Port != p.port {
+		return false
+	}
+
+	if p.subdomain {
+		// p.host holds the leading dot, so the apex itself does not match
+		return len(o.Host) > len(p.host) && strings.HasSuffix(o.Host, p.host)
+	}
+
+	return o.Host == p.host
+}
+
+// key returns the canonical form of the pattern, equal for patterns matching
+// the same origins. For literal patterns it is the canonical origin.
+func (p Pattern) key() string {
+	if p.IsLiteral() {
+		return Origin{Scheme: p.scheme, Host: p.host, Port: p.port}.String()
+	}
+
+	key := p.scheme + "://"
+	if p.subdomain {
+		key += "*"
+	}
+
+	key += p.host
+
+	switch {
+	case p.anyPort:
+		key += ":*"
+	case p.port != "":
+		key += ":" + p.port
+	}
+
+	return key
+}
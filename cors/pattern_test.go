@@ -0,0 +1,248 @@
+package cors_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		pattern string
+		literal bool
+		match   []string
+		miss    []string
+	}{
+		{
+			pattern: "https://example.com",
+			literal: true,
+			match:   []string{"https://example.com", "https://EXAMPLE.com:443"},
+			miss:    []string{"http://example.com", "https://a.example.com", "https://example.com:8443"},
+		},
+		{
+			pattern: "https://*.example.com",
+			match:   []string{"https://a.example.com", "https://a.b.example.com"},
+			miss:    []string{"https://example.com", "https://badexample.com", "http://a.example.com", "https://a.example.com:8443"},
+		},
+		{
+			pattern: "http://localhost:*",
+			match:   []string{"http://localhost", "http://localhost:3000"},
+			miss:    []string{"https://localhost:3000", "http://a.localhost:3000"},
+		},
+		{
+			pattern: "http://*.localhost:*",
+			match:   []string{"http://app.localhost:3000"},
+			miss:    []string{"http://localhost:3000", "http://app.localhost.example.com"},
+		},
+		{
+			pattern: "https://*.example.com:*",
+			match:   []string{"https://a.example.com:8443", "https://a.example.com"},
+			miss:    []string{"https://example.com:8443"},
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := cors.Compile(tt.pattern)
+		require.Nil(t, err, tt.pattern)
+		require.Equal(t, tt.literal, p.IsLiteral(), tt.pattern)
+		require.Equal(t, tt.pattern, p.String())
+
+		for _, origin := range tt.match {
+			require.True(t, p.Match(origin), "%s should match %s", tt.pattern, origin)
+		}
+
+		for _, origin := range tt.miss {
+			require.False(t, p.Match(origin), "%s should not match %s", tt.pattern, origin)
+		}
+	}
+}
+
+func TestOptions_Validate_PublicSuffixPattern(t *testing.T) {
+	for _, origins := range [][]string{{"https://*.com"}, {"https://app.example.com", "https://*.github.io"}} {
+		o := cors.NewOptions()
+		o.AllowOrigins = origins
+		require.True(t, errors.Is(o.Validate(), cors.ErrInvalidOrigin), origins)
+
+		h := o.NewHandler()
+		req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://evil.github.io")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Empty(t, rec.Header().Get(cors.HeaderAllowOrigin), origins)
+	}
+
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://*.example.co.uk"}
+	require.NoError(t, o.Validate())
+
+	// The guard can be lifted on purpose.
+	o.AllowOrigins = []string{"https://*.github.io"}
+	o.TimingAllowOrigins = []string{"https://*.io"}
+	o.AllowPublicSuffixPatterns = true
+	require.NoError(t, o.Validate())
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://docs.github.io")
+
+	rec := httptest.NewRecorder()
+	o.NewHandler().ServeHTTP(rec, req)
+	require.Equal(t, "https://docs.github.io", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "https://docs.github.io", rec.Header().Get(cors.HeaderTimingAllowOrigin))
+
+	_, err := cors.Compile("https://*.github.io")
+	require.True(t, errors.Is(err, cors.ErrInvalidOrigin))
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"null",
+		"example.com",
+		"https//example.com",
+		"https://example.com/",
+		"https://ex*mple.com",
+		"https://*example.com",
+		"https://a.*.example.com",
+		"*://example.com",
+		"https://example.com:8*",
+		"https://*.com",
+		"https://*.github.io",
+		"https://*.co.uk:*",
+		"http://*.test",
+	} {
+		_, err := cors.Compile(pattern)
+		require.NotNil(t, err, pattern)
+		require.True(t, errors.Is(err, cors.ErrInvalidOrigin), pattern)
+	}
+}
+
+func TestOptions_Validate_AllowOrigins(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"*", "https://example.com", "https://*.example.com"}
+	require.Nil(t, o.Validate())
+
+	o.AllowOrigins = []string{"https//example.com"}
+	require.NotNil(t, o.Validate())
+}
+
+func TestOptions_GetAllowOrigin_Patterns(t *testing.T) {
+	for _, build := range []bool{false, true} {
+		o := cors.NewOptions()
+		o.AllowOrigins = []string{"https://*.example.com"}
+
+		if build {
+			o.NewHandler()
+		}
+
+		req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+		req.Header.Set(cors.HeaderOrigin, "https://app.example.com")
+		require.Equal(t, "https://app.example.com", o.GetAllowOrigin(req))
+
+		req.Header.Set(cors.HeaderOrigin, "https://app.example.com.evil.io")
+		require.Equal(t, "", o.GetAllowOrigin(req))
+
+		require.Equal(t, cors.HeaderOrigin, o.GetVary())
+	}
+}
+
+func benchmarkPatterns(b *testing.B, cacheSize int) {
+	b.Helper()
+
+	o := cors.NewOptions()
+	o.OriginCacheSize = cacheSize
+
+	for i := 0; i < 100; i++ {
+		o.AllowOrigins = append(o.AllowOrigins, fmt.Sprintf("https://*.tenant-%d.example.com", i))
+	}
+
+	o.NewHandler()
+
+	req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+	req.Header.Set(cors.HeaderOrigin, "https://app.tenant-99.example.com")
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		o.GetAllowOrigin(req)
+	}
+}
+
+func BenchmarkOptions_GetAllowOrigin_PatternsUncached(b *testing.B) {
+	benchmarkPatterns(b, 0)
+}
+
+func BenchmarkOptions_GetAllowOrigin_PatternsCached(b *testing.B) {
+	benchmarkPatterns(b, cors.DefaultOriginCacheSize)
+}
+
+func TestOptions_Warnings(t *testing.T) {
+	tests := []struct {
+		origins  []string
+		expected []string
+	}{
+		{[]string{"https://example.com", "https://*.example.com", "self"}, nil},
+		{[]string{"https://example.com", "https://Example.com:443"}, []string{
+			`allowed origin "https://Example.com:443" duplicates "https://example.com"`,
+		}},
+		{[]string{"https://*.example.com", "HTTPS://*.EXAMPLE.COM", "self", "self"}, []string{
+			`allowed origin "HTTPS://*.EXAMPLE.COM" duplicates "https://*.example.com"`,
+			`allowed origin "self" duplicates "self"`,
+		}},
+		{[]string{"*", "https://example.com"}, []string{
+			`allowed origins other than "*" are shadowed by it`,
+		}},
+		{[]string{"https://app.example.com", "https://*.example.com", "http://localhost:3000", "http://localhost:*"}, []string{
+			`allowed origin "https://app.example.com" is shadowed by pattern "https://*.example.com"`,
+			`allowed origin "http://localhost:3000" is shadowed by pattern "http://localhost:*"`,
+		}},
+		{[]string{"https//example.com", "https//example.com"}, nil},
+	}
+
+	for _, tt := range tests {
+		o := cors.NewOptions()
+		o.AllowOrigins = tt.origins
+
+		require.Equal(t, tt.expected, o.Warnings(), "%v", tt.origins)
+	}
+}
+
+func TestOptions_Warnings_ExposeHeadersWildcard(t *testing.T) {
+	o := cors.NewOptions()
+	o.ExposeHeaders = []string{"*"}
+	require.Empty(t, o.Warnings())
+
+	o.AllowCredentials = true
+	require.Equal(t, []string{`exposed header "*" is not honored for requests with credentials: ` +
+		`list the headers to expose to them in ExposeHeadersCredentialFallback`}, o.Warnings())
+
+	o.ExposeHeadersCredentialFallback = []string{"X-Request-Id"}
+	require.Empty(t, o.Warnings())
+
+	o.ExposeHeadersCredentialFallback = nil
+	o.ExposeHeaders = []string{"*", "X-Request-Id"}
+	require.Empty(t, o.Warnings())
+}
+
+func TestOptions_NewHandler_DeduplicatesWithoutChangingBehavior(t *testing.T) {
+	origins := []string{"https://a.example.com", "https://*.example.com", "https://*.EXAMPLE.com", "https://b.example.com:443"}
+
+	scan := cors.NewOptions()
+	scan.AllowOrigins = origins
+
+	built := cors.NewOptions()
+	built.AllowOrigins = origins
+	built.NewHandler()
+
+	for _, origin := range []string{"https://a.example.com", "https://b.example.com", "https://c.example.com", "https://example.com", "http://a.example.com"} {
+		req := (*cors.Request)(httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil))
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		require.Equal(t, scan.GetAllowOrigin(req), built.GetAllowOrigin(req), origin)
+	}
+}
@@ -0,0 +1,572 @@
+package cors
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
+)
+
+// oversizedPreflight returns the status code answering a preflight request
+// over MaxPreflightHeaderCount or MaxPreflightBodyBytes, or zero. Preflights
+// are tiny by nature, so larger ones are scanners or abuse.
+func (o *Options) oversizedPreflight(r *Request) int {
+	if !o.isPreflight(r) {
+		return 0
+	}
+
+	if o.MaxPreflightHeaderCount > 0 && headerCount(r.Header) > o.MaxPreflightHeaderCount {
+		return statusOr(o.PreflightHeaderCountStatus, http.StatusRequestHeaderFieldsTooLarge)
+	}
+
+	if o.MaxPreflightBodyBytes > 0 && !bodyWithin(r, o.MaxPreflightBodyBytes) {
+		return statusOr(o.PreflightBodyStatus, http.StatusRequestEntityTooLarge)
+	}
+
+	return 0
+}
+
+// isPreflight reports whether r is answered as a preflight request: when it
+// IsPreflight, and under LenientPreflight when it is an OPTIONS request with
+// an Origin, even without an Access-Control-Request-Method.
+func (o *Options) isPreflight(r *Request) bool {
+	return r.IsPreflight() || o.LenientPreflight && r.Method == http.MethodOptions && r.origin() != ""
+}
+
+// safelistedMethods are the CORS-safelisted methods, which a preflight never
+// needs to be allowed explicitly.
+// See: Fetch Standard § 2.2.1. Methods.
+var safelistedMethods = map[string]struct{}{
+	http.MethodGet:  {},
+	http.MethodHead: {},
+	http.MethodPost: {},
+}
+
+// authorization is the Authorization request header, which the wildcard
+// Access-Control-Allow-Headers does not cover, and headerAuthorization its
+// lower case name.
+const (
+	authorization       = "Authorization"
+	headerAuthorization = "authorization"
+)
+
+// safelistedHeaders are the lower case CORS-safelisted request header names,
+// allowed without being listed when IncludeSafelistedHeaders is set.
+var safelistedHeaders = map[string]struct{}{
+	normalize.LowerASCII(HeaderAccept):          {},
+	normalize.LowerASCII(HeaderAcceptLanguage):  {},
+	normalize.LowerASCII(HeaderContentLanguage): {},
+	normalize.LowerASCII(HeaderContentType):     {},
+	normalize.LowerASCII(HeaderRange):           {},
+}
+
+// preflightEntry is the outcome of a preflight request from an allowed origin:
+// why it fails with the offending method or header, or ReasonAllowed and the
+// preflight specific response headers.
+type preflightEntry struct {
+	reason Reason
+	detail string
+	header http.Header
+}
+
+// preflight returns the outcome of the preflight request r from the allowed
+// origin, cached when PreflightCacheSize allows it. Cached headers are shared,
+// and must be copied before being modified.
+func (o *Options) preflight(r *Request, origin string) preflightEntry {
+	if o.preflights == nil {
+		return o.newPreflight(r)
+	}
+
+	key := preflightKey(r, origin)
+
+	if e, ok := o.preflights.get(key); ok {
+		return e.(preflightEntry)
+	}
+
+	e := o.newPreflight(r)
+	o.preflights.add(key, e)
+
+	return e
+}
+
+// preflightKey identifies the outcome of the preflight request r from origin.
+// The requested method and headers are keyed as sent rather than trimmed or
+// parsed, so the key holds exactly what preflightDenial validates and a hit
+// skips parsing altogether: browsers send them normalized already.
+func preflightKey(r *Request, origin string) string {
+	values, present := r.Header[HeaderRequestHeaders]
+
+	var b strings.Builder
+
+	b.WriteString(origin)
+	b.WriteByte(0)
+	b.WriteString(r.Header.Get(HeaderRequestMethod))
+
+	if present {
+		b.WriteByte(0)
+	}
+
+	for _, v := range values {
+		b.WriteByte('\n')
+		b.WriteString(v)
+	}
+
+	return b.String()
+}
+
+// newPreflight computes the outcome of the preflight request r, with the
+// AllowHeadersByMethod of its requested method, if any.
+func (o *Options) newPreflight(r *Request) preflightEntry {
+	if scoped, ok := o.byMethod[r.RequestedMethod()]; ok {
+		o = scoped
+	}
+
+	if reason, detail := o.preflightDenial(r); reason != ReasonAllowed {
+		return preflightEntry{reason: reason, detail: detail}
+	}
+
+	header := make(http.Header, 4)
+
+	if o.reflectsMethods() {
+		if method := r.RequestedMethod(); method != "" {
+			header.Set(HeaderAllowMethods, method)
+		}
+	} else if v := o.cache[HeaderAllowMethods]; v != "" {
+		header.Set(HeaderAllowMethods, v)
+	}
+
+	if v := o.allowHeaders(r); v != "" {
+		header.Set(HeaderAllowHeaders, v)
+	}
+
+	if v := o.cache[HeaderMaxAge]; v != "" {
+		header.Set(HeaderMaxAge, v)
+	}
+
+	return preflightEntry{reason: ReasonAllowed, header: header}
+}
+
+// preflightDenial returns why the method and headers requested by the
+// preflight request r are not allowed, as far as EnforceMethods and
+// EnforceHeaders require, with the offending method or header, or
+// ReasonAllowed. A requested method that is not a valid method, or more
+// requested headers than MaxRequestedHeaders, are never allowed. An absent
+// Access-Control-Request-Headers requests no header and always passes, while
+// EnforceHeaders fails one that is present but empty. Under
+// PartialAllowHeaders, disallowed headers are left out by allowHeaders instead.
+func (o *Options) preflightDenial(r *Request) (Reason, string) {
+	method := r.RequestedMethod()
+
+	switch {
+	case r.Header.Get(HeaderRequestMethod) == "":
+		// A LenientPreflight request without a method has none to check.
+	case !IsMethod(method):
+		return ReasonInvalidMethod, ""
+	case o.EnforceMethods && !o.allowsMethod(method):
+		return ReasonMethodNotAllowed, method
+	}
+
+	if o.MaxRequestedHeaders > 0 && !listWithin(r.Header.Values(HeaderRequestHeaders), o.MaxRequestedHeaders) {
+		return ReasonTooManyHeaders, ""
+	}
+
+	if o.EnforceHeaders {
+		names, present := r.LookupRequestedHeaders()
+		if present && len(names) == 0 {
+			return ReasonEmptyRequestHeaders, ""
+		}
+
+		for _, name := range names {
+			if !o.PartialAllowHeaders && !o.allowsHeader(name) {
+				return ReasonHeaderNotAllowed, name
+			}
+		}
+	}
+
+	return ReasonAllowed, ""
+}
+
+// IsMethod reports whether s is a syntactically valid HTTP method, that is a
+// token. Values such as "GET POST" or holding control characters are not.
+// See: RFC9110 § 9.1. Overview.
+func IsMethod(s string) bool {
+	return normalize.IsToken(s)
+}
+
+// allowsMethod reports whether method is safelisted, listed in the effective
+// AllowMethods built by NewHandler, or reflected under ReflectRequestMethods.
+// The wildcard allows any method: under AllowCredentials it is echoed as the
+// requested method, as the server cannot know whether the request carries
+// credentials.
+// See: Fetch Standard § 4.8. CORS-preflight fetch.
+func (o *Options) allowsMethod(method string) bool {
+	if _, ok := safelistedMethods[method]; ok || o.reflectsMethods() {
+		return true
+	}
+
+	for _, m := range o.methods {
+		if m == method || m == HeaderValueWildcard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reflectsMethods reports whether preflight requests are allowed the method
+// they request, as ReflectRequestMethods is set and AllowMethods is empty, or
+// the wildcard AllowMethods is echoed under AllowCredentials, since browsers
+// take it literally for requests with credentials.
+func (o *Options) reflectsMethods() bool {
+	return o.ReflectRequestMethods && len(o.AllowMethods) == 0 ||
+		o.AllowCredentials && o.allowsAllMethods()
+}
+
+// allowsAllMethods reports whether AllowMethods holds the wildcard.
+func (o *Options) allowsAllMethods() bool {
+	for _, m := range o.AllowMethods {
+		if !strings.Contains(m, ",") {
+			if normalize.TrimOWS(m) == HeaderValueWildcard {
+				return true
+			}
+
+			continue
+		}
+
+		for _, e := range methodList(m) {
+			if e == HeaderValueWildcard {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// knownMethods are the methods defined by RFC9110 and PATCH. Other valid
+// tokens are allowed, but reported by Warnings as likely typos.
+// See: RFC9110 § 9. Methods.
+var knownMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodPost:    {},
+	http.MethodPut:     {},
+	http.MethodDelete:  {},
+	http.MethodConnect: {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+	http.MethodPatch:   {},
+}
+
+// methodList returns the methods of an AllowMethods entry in upper case, as
+// an entry may hold several comma-separated methods, such as "Get, Post".
+func methodList(entry string) []string {
+	methods, _ := normalize.SplitList(entry, -1)
+
+	for i, m := range methods {
+		methods[i] = strings.ToUpper(m)
+	}
+
+	return methods
+}
+
+// defaultMethods are added to AllowMethods under IncludeDefaultMethods.
+var defaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+
+// allowMethods returns the effective AllowMethods: the methods of its entries
+// in upper case and in order, each listed once, followed under
+// IncludeDefaultMethods by the defaultMethods it lacks. Requested methods are
+// compared to these case-sensitively, as browsers do.
+func (o *Options) allowMethods() []string {
+	methods := make([]string, 0, len(o.AllowMethods)+len(defaultMethods))
+
+	add := func(m string) {
+		for _, existing := range methods {
+			if existing == m {
+				return
+			}
+		}
+
+		methods = append(methods, m)
+	}
+
+	for _, m := range o.AllowMethods {
+		for _, e := range methodList(m) {
+			add(e)
+		}
+	}
+
+	if o.IncludeDefaultMethods {
+		for _, m := range defaultMethods {
+			add(m)
+		}
+	}
+
+	return methods
+}
+
+// listWithin reports whether the comma-separated values hold at most max
+// non-empty elements. It stops counting past max and does not allocate, so it
+// is cheap on hostile lists.
+func listWithin(values []string, max int) bool {
+	n := 0
+
+	for _, v := range values {
+		count, ok := normalize.CountList(v, max-n)
+		if !ok {
+			return false
+		}
+
+		n += count
+	}
+
+	return true
+}
+
+// ParseRequestHeaders parses the values of the Access-Control-Request-Headers
+// fields of a request, which intermediaries may have split across several
+// lines. It returns the lower case header names in order of first appearance,
+// with optional whitespace trimmed and empty elements and duplicates dropped.
+// The result is nil when no header is listed.
+func ParseRequestHeaders(values ...string) []string {
+	var (
+		names []string
+		seen  map[string]struct{}
+	)
+
+	for _, v := range values {
+		elements, _ := normalize.SplitList(v, -1)
+
+		for _, e := range elements {
+			e = normalize.LowerASCII(e)
+
+			if _, ok := seen[e]; ok {
+				continue
+			}
+
+			if seen == nil {
+				seen = make(map[string]struct{})
+			}
+
+			seen[e] = struct{}{}
+			names = append(names, e)
+		}
+	}
+
+	return names
+}
+
+// allowsHeader reports whether the lower case header name is listed in
+// AllowHeaders, safelisted under IncludeSafelistedHeaders, or a valid name
+// reflected under ReflectRequestHeaders. The wildcard does not cover
+// Authorization, unless credentials are allowed and the requested headers are
+// echoed, or IncludeAuthorizationWithWildcard adds it.
+// See: Fetch Standard § 4.8. CORS-preflight fetch.
+func (o *Options) allowsHeader(name string) bool {
+	if o.reflectsHeaders() {
+		return normalize.IsToken(name)
+	}
+
+	if _, ok := safelistedHeaders[name]; ok && o.IncludeSafelistedHeaders {
+		return true
+	}
+
+	if _, ok := o.headers[name]; ok {
+		return true
+	}
+
+	_, wildcard := o.headers[HeaderValueWildcard]
+
+	return wildcard && (o.AllowCredentials || o.IncludeAuthorizationWithWildcard || name != headerAuthorization)
+}
+
+// scopeByMethod returns the Options used for preflight requests by the
+// methods of AllowHeadersByMethod: copies of o built by NewHandler, whose
+// AllowHeaders are those of the method. Methods are upper-cased like those of
+// AllowMethods, and requested methods compared case-sensitively. The first
+// entry in sorted order wins for a method listed by several ones.
+func (o *Options) scopeByMethod() map[string]*Options {
+	if len(o.AllowHeadersByMethod) == 0 {
+		return nil
+	}
+
+	scopes := make(map[string]*Options, len(o.AllowHeadersByMethod))
+
+	for _, key := range sortedKeys(o.AllowHeadersByMethod) {
+		scoped := *o
+		scoped.AllowHeaders = o.AllowHeadersByMethod[key]
+		scoped.AllowHeadersByMethod = nil
+		scoped.byMethod = nil
+		scoped.headers = allowedHeaders(canonicalHeaders(scoped.AllowHeaders))
+		scoped.cache = make(map[string]string, len(o.cache))
+
+		for k, v := range o.cache {
+			scoped.cache[k] = v
+		}
+
+		delete(scoped.cache, HeaderAllowHeaders)
+
+		if !scoped.reflectsHeaders() {
+			scoped.cache[HeaderAllowHeaders] = scoped.GetAllowHeaders()
+		}
+
+		for _, m := range methodList(key) {
+			if _, ok := scopes[m]; !ok {
+				scopes[m] = &scoped
+			}
+		}
+	}
+
+	return scopes
+}
+
+// scopeVariesOnRequestHeaders reports whether the preflight responses of any
+// method of AllowHeadersByMethod depend on their
+// Access-Control-Request-Headers.
+func (o *Options) scopeVariesOnRequestHeaders() bool {
+	for _, scoped := range o.byMethod {
+		if scoped.variesOnRequestHeaders() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// allowedHeaders maps the lower case names of headers to their first spelling
+// in the canonical AllowHeaders, so requested headers are matched
+// case-insensitively and echoed in canonical form.
+func allowedHeaders(headers []string) map[string]string {
+	m := make(map[string]string, len(headers))
+
+	for _, h := range headers {
+		h = normalize.TrimOWS(h)
+		name := normalize.LowerASCII(h)
+
+		if _, ok := m[name]; !ok && h != "" {
+			m[name] = h
+		}
+	}
+
+	return m
+}
+
+// echoesHeaders reports whether preflight responses echo the requested
+// headers they allow, as they do under PartialAllowHeaders and
+// reflectsHeaders, and when the wildcard is combined with credentials:
+// browsers take the wildcard literally for credentialed requests.
+// See: Fetch Standard § 3.2.5. CORS protocol and credentials.
+func (o *Options) echoesHeaders() bool {
+	return o.PartialAllowHeaders || o.reflectsHeaders() ||
+		o.AllowCredentials && o.cache[HeaderAllowHeaders] == HeaderValueWildcard
+}
+
+// reflectsHeaders reports whether preflight requests are allowed the headers
+// they request, as ReflectRequestHeaders is set and AllowHeaders is empty.
+func (o *Options) reflectsHeaders() bool {
+	return o.ReflectRequestHeaders && len(o.AllowHeaders) == 0
+}
+
+// addsAuthorization reports whether IncludeAuthorizationWithWildcard applies:
+// the wildcard Access-Control-Allow-Headers is sent, and never covers
+// Authorization.
+// See: Fetch Standard § 3.2.4. HTTP new-header syntax.
+func (o *Options) addsAuthorization() bool {
+	return o.IncludeAuthorizationWithWildcard && !o.AllowCredentials &&
+		o.cache[HeaderAllowHeaders] == HeaderValueWildcard
+}
+
+// variesOnRequestHeaders reports whether the Access-Control-Allow-Headers of
+// preflight responses depends on their Access-Control-Request-Headers.
+func (o *Options) variesOnRequestHeaders() bool {
+	return o.echoesHeaders() || o.addsAuthorization()
+}
+
+// allowHeaders returns the Access-Control-Allow-Headers of the preflight
+// request r: the valid header names of its Access-Control-Request-Headers
+// that allowsHeader and are not forbidden, in canonical form when listed in
+// AllowHeaders, when echoesHeaders, and the
+// list built by NewHandler otherwise, followed by Authorization when
+// addsAuthorization and r requests it.
+func (o *Options) allowHeaders(r *Request) string {
+	if o.addsAuthorization() {
+		for _, name := range r.RequestedHeaders() {
+			if name == headerAuthorization {
+				return o.joinList([]string{HeaderValueWildcard, authorization})
+			}
+		}
+	}
+
+	if !o.echoesHeaders() {
+		return o.cache[HeaderAllowHeaders]
+	}
+
+	requested := r.RequestedHeaders()
+	names := requested[:0]
+
+	for _, name := range requested {
+		if !normalize.IsToken(name) || isForbiddenHeader(name) || !o.allowsHeader(name) {
+			continue
+		}
+
+		if configured, ok := o.headers[name]; ok {
+			name = configured
+		}
+
+		names = append(names, name)
+	}
+
+	return o.joinList(names)
+}
+
+// headerCount returns the number of values in header.
+func headerCount(header http.Header) int {
+	n := 0
+	for _, v := range header {
+		n += len(v)
+	}
+
+	return n
+}
+
+// bodyWithin reports whether the request body holds at most max bytes. A body
+// of unknown length is read up to one byte past max, then restored.
+func bodyWithin(r *Request, max int64) bool {
+	if r.ContentLength > max {
+		return false
+	}
+
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return true
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(b), r.Body))
+
+	return err == nil && int64(len(b)) <= max
+}
+
+// statusOr returns status, or fallback when status is zero.
+func statusOr(status, fallback int) int {
+	if status == 0 {
+		return fallback
+	}
+
+	return status
+}
@@ -0,0 +1,253 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequestHeaders(t *testing.T) {
+	tests := []struct {
+		values   []string
+		expected []string
+	}{
+		{nil, nil},
+		{[]string{""}, nil},
+		{[]string{" , ,, "}, nil},
+		{[]string{"content-type"}, []string{"content-type"}},
+		{[]string{"a,,b , ,A"}, []string{"a", "b"}},
+		{[]string{"\tX-Request-ID ,authorization,"}, []string{"x-request-id", "authorization"}},
+		{[]string{"x-a, x-b", "X-B, x-c", ""}, []string{"x-a", "x-b", "x-c"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, cors.ParseRequestHeaders(test.values...), test.values)
+	}
+}
+
+func TestParseRequestHeaders_Properties(t *testing.T) {
+	property := func(values []string) bool {
+		names := cors.ParseRequestHeaders(values...)
+		seen := make(map[string]bool, len(names))
+
+		for _, name := range names {
+			if name == "" || seen[name] || strings.ContainsAny(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ,") {
+				return false
+			}
+
+			if strings.Trim(name, " \t") != name {
+				return false
+			}
+
+			seen[name] = true
+		}
+
+		// Parsing is stable: the joined result parses to itself, and splitting
+		// the input across lines does not change it.
+		again := cors.ParseRequestHeaders(strings.Join(names, ", "))
+		joined := cors.ParseRequestHeaders(strings.Join(values, ","))
+
+		return equalStrings(names, again) && equalStrings(names, joined)
+	}
+
+	require.Nil(t, quick.Check(property, nil))
+
+	for _, hostile := range []string{",", ",,,,", "a,,b , ,A", "\t,\t", strings.Repeat("x,", 10000), "\x00,\xff, ,"} {
+		require.NotPanics(t, func() { cors.ParseRequestHeaders(hostile) }, hostile)
+	}
+}
+
+func TestRequest_RequestedHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	require.Empty(t, (*cors.Request)(req).RequestedHeaders())
+
+	req.Header.Add(cors.HeaderRequestHeaders, "Content-Type, x-a")
+	req.Header.Add(cors.HeaderRequestHeaders, " X-A ,x-b,")
+	require.Equal(t, []string{"content-type", "x-a", "x-b"}, (*cors.Request)(req).RequestedHeaders())
+
+	var nilRequest *cors.Request
+	require.NotNil(t, nilRequest.RequestedHeaders())
+	require.Empty(t, nilRequest.RequestedHeaders())
+	require.NotNil(t, (*cors.Request)(httptest.NewRequest(http.MethodOptions, "/", nil)).RequestedHeaders())
+}
+
+func TestRequest_LookupRequestedHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+
+	names, present := (*cors.Request)(req).LookupRequestedHeaders()
+	require.Nil(t, names)
+	require.False(t, present)
+	require.True(t, (*cors.Request)(req).IsPreflight())
+
+	for _, value := range []string{"", " ", " , ,"} {
+		req.Header.Set(cors.HeaderRequestHeaders, value)
+
+		names, present = (*cors.Request)(req).LookupRequestedHeaders()
+		require.Equal(t, []string{}, names, value)
+		require.True(t, present, value)
+		require.True(t, (*cors.Request)(req).IsPreflight(), value)
+	}
+
+	req.Header.Set(cors.HeaderRequestHeaders, "X-A")
+
+	names, present = (*cors.Request)(req).LookupRequestedHeaders()
+	require.Equal(t, []string{"x-a"}, names)
+	require.True(t, present)
+
+	var nilRequest *cors.Request
+
+	names, present = nilRequest.LookupRequestedHeaders()
+	require.Nil(t, names)
+	require.False(t, present)
+}
+
+func TestHandler_ServeHTTP_EmptyRequestHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowHeaders = []string{"X-A"}
+
+	preflight := func(headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+		for _, h := range headers {
+			req.Header.Add(cors.HeaderRequestHeaders, h)
+		}
+
+		rec := httptest.NewRecorder()
+		o.NewHandler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	require.Equal(t, http.StatusNoContent, preflight().Code)
+	require.Equal(t, http.StatusNoContent, preflight("").Code)
+
+	o.EnforceHeaders = true
+	require.Equal(t, http.StatusNoContent, preflight().Code)
+	require.Equal(t, http.StatusNoContent, preflight("x-a").Code)
+	require.Equal(t, http.StatusForbidden, preflight("").Code)
+	require.Equal(t, http.StatusForbidden, preflight(" , ").Code)
+	require.Equal(t, http.StatusNoContent, preflight("", "x-a").Code)
+}
+
+func TestRequest_RequestedMethod(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	require.Empty(t, (*cors.Request)(req).RequestedMethod())
+
+	req.Header.Set(cors.HeaderRequestMethod, " PUT\t")
+	require.Equal(t, http.MethodPut, (*cors.Request)(req).RequestedMethod())
+
+	var nilRequest *cors.Request
+	require.Empty(t, nilRequest.RequestedMethod())
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestHandler_ServeHTTP_AllowHeadersByMethod(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.AllowMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	o.AllowHeaders = []string{"Content-Type"}
+	o.AllowHeadersByMethod = map[string][]string{
+		"post, PUT":        {"Content-Type", "x-idempotency-key"},
+		http.MethodDelete:  {"*"},
+		http.MethodOptions: nil,
+	}
+	o.EnforceHeaders = true
+	o.PreflightCacheSize = 8
+	require.NoError(t, o.Validate())
+
+	h := o.NewHandler()
+
+	serve := func(method, headers string) http.Header {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, method)
+		req.Header.Set(cors.HeaderRequestHeaders, headers)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	tests := []struct {
+		method   string
+		headers  string
+		expected string
+	}{
+		{http.MethodPost, "x-idempotency-key", "Content-Type, X-Idempotency-Key"},
+		{http.MethodPut, "x-idempotency-key", "Content-Type, X-Idempotency-Key"},
+		{http.MethodGet, "x-idempotency-key", ""},
+		{http.MethodGet, "content-type", "Content-Type"},
+		{http.MethodDelete, "x-anything", "*"},
+		// the cached outcome of POST does not leak to GET, nor the reverse
+		{http.MethodGet, "x-idempotency-key", ""},
+		{http.MethodPost, "x-idempotency-key", "Content-Type, X-Idempotency-Key"},
+	}
+
+	for _, test := range tests {
+		header := serve(test.method, test.headers)
+		require.Equal(t, test.expected, header.Get(cors.HeaderAllowHeaders), "%s %s", test.method, test.headers)
+		require.Contains(t, header.Get(cors.HeaderVary), cors.HeaderRequestMethod)
+	}
+
+	// Without other dependencies, the preflight response still varies on the
+	// requested method.
+	o.EnforceMethods = false
+	o.EnforceHeaders = false
+	require.Equal(t, "Origin, Access-Control-Request-Method", o.GetPreflightVary())
+
+	o.AllowHeadersByMethod = map[string][]string{"*": {"X-Token"}}
+	require.Error(t, o.Validate())
+
+	o.AllowHeadersByMethod = map[string][]string{"GET POST": {"X-Token"}}
+	require.Error(t, o.Validate())
+}
+
+func TestHandler_ServeHTTP_PreflightCacheKeysRawMethod(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://example.com"}
+	o.LenientPreflight = true
+	o.PreflightCacheSize = 8
+
+	h := o.NewHandler()
+
+	serve := func(method ...string) int {
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header[cors.HeaderRequestMethod] = method
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	// A blank method trims to the same value as a missing one, but is invalid:
+	// the outcome cached for one must not answer the other.
+	require.Equal(t, http.StatusNoContent, serve())
+	require.Equal(t, http.StatusForbidden, serve(" "))
+	require.Equal(t, http.StatusNoContent, serve())
+}
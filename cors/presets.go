@@ -0,0 +1,60 @@
+package cors
+
+// Names of the built-in header presets returned by HeaderPreset.
+const (
+	// PresetStandardAPI lists the request headers commonly sent to JSON APIs.
+	PresetStandardAPI = "standard-api"
+	// PresetPagination lists the response headers commonly used to paginate
+	// collections.
+	PresetPagination = "pagination"
+	// PresetDownloads lists the response headers scripts need to read when
+	// downloading files, in full or by range.
+	PresetDownloads = "downloads"
+)
+
+var headerPresets = map[string][]string{
+	PresetStandardAPI: {
+		"Accept",
+		"Accept-Language",
+		"Authorization",
+		"Content-Language",
+		"Content-Type",
+		"If-Match",
+		"If-None-Match",
+		"X-Request-Id",
+		"X-Requested-With",
+	},
+	PresetPagination: {
+		"Link",
+		"X-Total-Count",
+	},
+	PresetDownloads: {
+		"Content-Disposition",
+		"Content-Length",
+		"Content-Range",
+		"Accept-Ranges",
+		"ETag",
+	},
+}
+
+// HeaderPreset returns a copy of the built-in header list registered under
+// name, for use in AllowHeaders or ExposeHeaders. The boolean is false if no
+// such preset exists.
+func HeaderPreset(name string) ([]string, bool) {
+	p, ok := headerPresets[name]
+	if !ok {
+		return nil, false
+	}
+
+	return append([]string(nil), p...), true
+}
+
+// ExposeDownloadHeaders returns a copy of the PresetDownloads headers, which
+// are not exposed to scripts otherwise, for use in ExposeHeaders: the file
+// name of Content-Disposition, the size of Content-Length and the ranges of
+// Content-Range and Accept-Ranges, as well as the ETag to resume with.
+func ExposeDownloadHeaders() []string {
+	p, _ := HeaderPreset(PresetDownloads)
+
+	return p
+}
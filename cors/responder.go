@@ -0,0 +1,25 @@
+package cors
+
+import "net/http"
+
+// PreflightResponder terminates a CORS preflight request. The handler calls it
+// once it has written the headers shared by all CORS responses, passing the
+// preflight specific headers and the status code to respond with.
+type PreflightResponder interface {
+	RespondPreflight(rw http.ResponseWriter, req *Request, header http.Header, status int)
+}
+
+// DefaultPreflightResponder copies header onto the response and writes status,
+// without a body.
+var DefaultPreflightResponder PreflightResponder = defaultPreflightResponder{}
+
+type defaultPreflightResponder struct{}
+
+// RespondPreflight implements PreflightResponder.
+func (defaultPreflightResponder) RespondPreflight(rw http.ResponseWriter, _ *Request, header http.Header, status int) {
+	for k, v := range header {
+		rw.Header()[k] = v
+	}
+
+	rw.WriteHeader(status)
+}
@@ -0,0 +1,211 @@
+package cors
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
+)
+
+// Limits on the values of CORS-safelisted request headers.
+// See: Fetch Standard § 2.2.2. Headers.
+const (
+	maxSafelistedValueLength = 128
+	maxSafelistedTotalLength = 1024
+)
+
+// simpleContentTypes are the Content-Type essences a cross-origin request may
+// use without a preflight, as HTML forms do.
+var simpleContentTypes = map[string]struct{}{
+	"application/x-www-form-urlencoded": {},
+	"multipart/form-data":               {},
+	"text/plain":                        {},
+}
+
+// forbiddenHeaders are the lower case forbidden request-header names, which
+// scripts cannot set, beside those starting with forbiddenHeaderPrefixes.
+// See: Fetch Standard § 2.2.2. Headers.
+var forbiddenHeaders = map[string]struct{}{
+	"accept-charset":                 {},
+	"accept-encoding":                {},
+	"access-control-request-headers": {},
+	"access-control-request-method":  {},
+	"connection":                     {},
+	"content-length":                 {},
+	"cookie":                         {},
+	"cookie2":                        {},
+	"date":                           {},
+	"dnt":                            {},
+	"expect":                         {},
+	"host":                           {},
+	"keep-alive":                     {},
+	"origin":                         {},
+	"referer":                        {},
+	"set-cookie":                     {},
+	"te":                             {},
+	"trailer":                        {},
+	"transfer-encoding":              {},
+	"upgrade":                        {},
+	"via":                            {},
+}
+
+var forbiddenHeaderPrefixes = []string{"proxy-", "sec-"}
+
+// isForbiddenHeader reports whether the lower case header name is a forbidden
+// request-header name. Browsers never request such headers in a preflight, so
+// they only show up in hostile or broken requests.
+func isForbiddenHeader(name string) bool {
+	if _, ok := forbiddenHeaders[name]; ok {
+		return true
+	}
+
+	for _, prefix := range forbiddenHeaderPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Forbidden response-header names, which browsers never expose to scripts,
+// whatever Access-Control-Expose-Headers lists.
+// See: Fetch Standard § 2.2.2. Headers.
+const (
+	ForbiddenResponseHeaderSetCookie  = "Set-Cookie"
+	ForbiddenResponseHeaderSetCookie2 = "Set-Cookie2"
+)
+
+// IsForbiddenResponseHeader reports whether name is a forbidden response-header
+// name, compared case-insensitively.
+func IsForbiddenResponseHeader(name string) bool {
+	switch normalize.LowerASCII(normalize.TrimOWS(name)) {
+	case "set-cookie", "set-cookie2":
+		return true
+	}
+
+	return false
+}
+
+// RequiresPreflight reports whether a browser sends a preflight request before
+// a cross-origin request with method and the request headers set by the
+// script in headers. It does not unless the method is CORS-safelisted and
+// every header is a CORS-safelisted request header whose value is safelisted
+// too, and these values do not exceed 1024 bytes in total. Each value of a
+// header is checked on its own, as the entries of a fetch() header list are.
+// See: Fetch Standard § 4.1. Main fetch.
+// See: Fetch Standard § 2.2.2. Headers.
+func RequiresPreflight(method string, headers http.Header) bool {
+	if _, ok := safelistedMethods[method]; !ok {
+		return true
+	}
+
+	total := 0
+
+	for name, values := range headers {
+		name = normalize.LowerASCII(name)
+
+		for _, value := range values {
+			if !isSafelistedHeader(name, value) {
+				return true
+			}
+
+			total += len(value)
+		}
+	}
+
+	return total > maxSafelistedTotalLength
+}
+
+// isSafelistedHeader reports whether the lower case header name and its value
+// form a CORS-safelisted request header.
+// See: Fetch Standard § 2.2.2. Headers.
+func isSafelistedHeader(name, value string) bool {
+	if len(value) > maxSafelistedValueLength {
+		return false
+	}
+
+	switch name {
+	case "accept":
+		return !hasUnsafeByte(value)
+	case "accept-language", "content-language":
+		for i := 0; i < len(value); i++ {
+			c := value[i]
+			if !('0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' ||
+				strings.IndexByte(" *,-.;=", c) >= 0) {
+				return false
+			}
+		}
+
+		return true
+	case "content-type":
+		if hasUnsafeByte(value) {
+			return false
+		}
+
+		essence, _, err := mime.ParseMediaType(value)
+		if err != nil {
+			return false
+		}
+
+		_, ok := simpleContentTypes[essence]
+
+		return ok
+	case "range":
+		return isSimpleRange(value)
+	}
+
+	return false
+}
+
+// hasUnsafeByte reports whether value holds a CORS-unsafe request-header byte.
+func hasUnsafeByte(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 && c != '\t' || c == 0x7f || strings.IndexByte("\"():<>?@[\\]{}", c) >= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSimpleRange reports whether value is a single byte range with a start,
+// such as "bytes=0-" or "bytes=0-499".
+func isSimpleRange(value string) bool {
+	if !strings.HasPrefix(value, "bytes=") {
+		return false
+	}
+
+	dash := strings.IndexByte(value, '-')
+	if dash < 0 {
+		return false
+	}
+
+	start, end := value[len("bytes="):dash], value[dash+1:]
+
+	first, err := strconv.ParseUint(start, 10, 64)
+	if err != nil || !isDigits(start) {
+		return false
+	}
+
+	if end == "" {
+		return true
+	}
+
+	last, err := strconv.ParseUint(end, 10, 64)
+
+	return err == nil && isDigits(end) && first <= last
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return s != ""
+}
@@ -0,0 +1,124 @@
+package cors_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiresPreflight(t *testing.T) {
+	tests := []struct {
+		method   string
+		headers  http.Header
+		expected bool
+	}{
+		{http.MethodGet, nil, false},
+		{http.MethodHead, http.Header{}, false},
+		{http.MethodPost, nil, false},
+		{http.MethodPut, nil, true},
+		{http.MethodDelete, nil, true},
+		{http.MethodPatch, nil, true},
+		{"get", nil, true},
+		{http.MethodOptions, nil, true},
+
+		// Accept
+		{http.MethodGet, http.Header{"Accept": {"application/json, text/*;q=0.8"}}, false},
+		{http.MethodGet, http.Header{"Accept": {"application/json", "text/html"}}, false},
+		{http.MethodGet, http.Header{"Accept": {"text/html\x01"}}, true},
+		{http.MethodGet, http.Header{"Accept": {`text/"html"`}}, true},
+		{http.MethodGet, http.Header{"Accept": {strings.Repeat("a", 129)}}, true},
+		{http.MethodGet, http.Header{"Accept": {strings.Repeat("a", 128)}}, false},
+
+		// Accept-Language and Content-Language
+		{http.MethodGet, http.Header{"Accept-Language": {"en-US,en;q=0.5"}}, false},
+		{http.MethodGet, http.Header{"Accept-Language": {"*"}}, false},
+		{http.MethodGet, http.Header{"Accept-Language": {"en_US"}}, true},
+		{http.MethodPost, http.Header{"Content-Language": {"de-DE, en-CA"}}, false},
+		{http.MethodPost, http.Header{"Content-Language": {"de/DE"}}, true},
+
+		// Content-Type
+		{http.MethodPost, http.Header{"Content-Type": {"text/plain"}}, false},
+		{http.MethodPost, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, false},
+		{http.MethodPost, http.Header{"Content-Type": {"TEXT/PLAIN"}}, false},
+		{http.MethodPost, http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, false},
+		{http.MethodPost, http.Header{"Content-Type": {"multipart/form-data; boundary=x"}}, false},
+		{http.MethodPost, http.Header{"Content-Type": {"application/json"}}, true},
+		{http.MethodPost, http.Header{"Content-Type": {"text/plain; charset=\"utf-8\""}}, true},
+		{http.MethodPost, http.Header{"Content-Type": {"text/plain", "text/plain"}}, false},
+		{http.MethodPost, http.Header{"Content-Type": {"text/plain", "application/json"}}, true},
+		{http.MethodPost, http.Header{"Content-Type": {"not a type"}}, true},
+
+		// Range
+		{http.MethodGet, http.Header{"Range": {"bytes=0-"}}, false},
+		{http.MethodGet, http.Header{"Range": {"bytes=0-499"}}, false},
+		{http.MethodGet, http.Header{"Range": {"bytes=500-499"}}, true},
+		{http.MethodGet, http.Header{"Range": {"bytes=-500"}}, true},
+		{http.MethodGet, http.Header{"Range": {"bytes=0-1,5-6"}}, true},
+		{http.MethodGet, http.Header{"Range": {"bytes=+1-"}}, true},
+		{http.MethodGet, http.Header{"Range": {"items=0-1"}}, true},
+
+		// Other headers
+		{http.MethodGet, http.Header{"Authorization": {"Bearer token"}}, true},
+		{http.MethodGet, http.Header{"X-Requested-With": {"XMLHttpRequest"}}, true},
+		{http.MethodGet, http.Header{"accept": {"*/*"}, "content-language": {"en"}}, false},
+
+		// Total length of the safelisted values
+		{http.MethodGet, http.Header{
+			"Accept":           {strings.Repeat("a", 128)},
+			"Accept-Language":  {strings.Repeat("b", 128)},
+			"Content-Language": {strings.Repeat("c", 128)},
+		}, false},
+		{http.MethodPost, http.Header{"Accept": repeat(strings.Repeat("a", 128), 8)}, false},
+		{http.MethodPost, http.Header{"Accept": repeat(strings.Repeat("a", 128), 8), "Range": {"bytes=0-"}}, true},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, cors.RequiresPreflight(test.method, test.headers), "%s %v", test.method, test.headers)
+	}
+}
+
+func repeat(value string, n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = value
+	}
+
+	return values
+}
+
+func TestIsForbiddenResponseHeader(t *testing.T) {
+	for _, name := range []string{cors.ForbiddenResponseHeaderSetCookie, cors.ForbiddenResponseHeaderSetCookie2, "set-cookie", " SET-COOKIE "} {
+		require.True(t, cors.IsForbiddenResponseHeader(name), name)
+	}
+
+	for _, name := range []string{"", "Cookie", "Set-Cookie3", "X-Set-Cookie", "Authorization"} {
+		require.False(t, cors.IsForbiddenResponseHeader(name), name)
+	}
+}
+
+func TestOptions_ForbiddenExposeHeaders(t *testing.T) {
+	o := cors.NewOptions()
+	o.ExposeHeaders = []string{"X-Request-Id", " set-cookie", "Set-Cookie2", "Link"}
+	require.EqualError(t, o.Validate(), `exposed header "set-cookie" is a forbidden response-header name browsers never expose`)
+	require.Equal(t, "X-Request-Id, Link", o.GetExposeHeaders())
+
+	o.ExposeHeaders = []string{"*"}
+	o.ExposeHeadersCredentialFallback = []string{"Set-Cookie", "X-Request-Id"}
+	o.AllowCredentials = true
+	require.Error(t, o.Validate())
+	require.Equal(t, "*, X-Request-Id", o.GetExposeHeaders())
+
+	o.KeepForbiddenExposeHeaders = true
+	require.NoError(t, o.Validate())
+	require.Equal(t, "*, Set-Cookie, X-Request-Id", o.GetExposeHeaders())
+
+	o.ExposeHeaders = []string{"X-Request-Id", "set-cookie"}
+	require.Equal(t, "X-Request-Id, Set-Cookie", o.GetExposeHeaders())
+
+	o.ExposeHeaders = []string{"Set-Cookie"}
+	o.KeepForbiddenExposeHeaders = false
+	require.Empty(t, o.GetExposeHeaders())
+}
@@ -0,0 +1,54 @@
+package cors
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Stats holds counters of requests handled by a handler since NewHandler was
+// called.
+type Stats struct {
+	// OversizedOrigins is the number of requests whose Origin exceeded
+	// MaxOriginLength and were processed as non-CORS requests.
+	OversizedOrigins uint64
+	// OversizedPreflights is the number of preflight requests rejected for
+	// exceeding MaxPreflightHeaderCount or MaxPreflightBodyBytes.
+	OversizedPreflights uint64
+	// StrictModeRejections is the number of requests StrictMode answered
+	// with StrictModeStatus on an internal error.
+	StrictModeRejections uint64
+}
+
+// stats holds the live counters behind Stats. It is allocated separately so its
+// fields are 64-bit aligned for atomic access on every platform.
+type stats struct {
+	oversizedOrigins     uint64
+	oversizedPreflights  uint64
+	strictModeRejections uint64
+}
+
+// Stats returns a snapshot of the counters of the handler created from the
+// Options. It returns zero counters before NewHandler is called.
+func (o *Options) Stats() Stats {
+	if o.stats == nil {
+		return Stats{}
+	}
+
+	return Stats{
+		OversizedOrigins:     atomic.LoadUint64(&o.stats.oversizedOrigins),
+		OversizedPreflights:  atomic.LoadUint64(&o.stats.oversizedPreflights),
+		StrictModeRejections: atomic.LoadUint64(&o.stats.strictModeRejections),
+	}
+}
+
+// logf logs an internal error to ErrorLog, or to the standard logger when it
+// is nil.
+func (o *Options) logf(format string, args ...interface{}) {
+	if o.ErrorLog != nil {
+		o.ErrorLog.Printf(format, args...)
+
+		return
+	}
+
+	log.Printf(format, args...)
+}
@@ -0,0 +1,64 @@
+package cors
+
+// GetTimingAllowOrigin returns the appropriate Timing-Allow-Origin header,
+// which lets the request's origin read the full Resource Timing data of the
+// response. TimingAllowOrigins is matched like AllowOrigins, literal origins
+// and patterns alike, but apart from the CORS decision, so an origin can be
+// allowed to read timings without being allowed to read the response. The
+// wildcard is returned as is, since the header does not depend on the
+// credentials mode. An empty string represents that no Timing-Allow-Origin
+// should be returned to the client.
+// See: Resource Timing § Timing-Allow-Origin Response Header.
+func (o *Options) GetTimingAllowOrigin(request *Request) string {
+	origin := request.origin()
+	if origin == "" || o.oversizedOrigin(request) {
+		return ""
+	}
+
+	patterns, wildcard := o.timing, o.timingWildcard
+	if o.cache == nil {
+		patterns, wildcard = o.compileTimingOrigins()
+	}
+
+	if wildcard {
+		return HeaderValueWildcard
+	}
+
+	for _, p := range patterns {
+		if p.Match(origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// compileTimingOrigins compiles the TimingAllowOrigins entries, reporting
+// whether the wildcard is among them. Invalid entries are skipped.
+func (o *Options) compileTimingOrigins() ([]Pattern, bool) {
+	var patterns []Pattern
+
+	for _, v := range o.TimingAllowOrigins {
+		if v == HeaderValueWildcard {
+			return nil, true
+		}
+
+		if p, err := o.compile(v); err == nil {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns, false
+}
+
+// variesOnTimingOrigin reports whether the Timing-Allow-Origin of a response
+// depends on the request's Origin.
+func (o *Options) variesOnTimingOrigin() bool {
+	for _, v := range o.TimingAllowOrigins {
+		if v == HeaderValueWildcard {
+			return false
+		}
+	}
+
+	return len(o.TimingAllowOrigins) > 0
+}
@@ -0,0 +1,111 @@
+package cors_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions_GetTimingAllowOrigin(t *testing.T) {
+	tests := []struct {
+		origins  []string
+		origin   string
+		expected string
+	}{
+		{nil, "https://example.com", ""},
+		{[]string{"*"}, "https://example.com", "*"},
+		{[]string{"*"}, "null", "*"},
+		{[]string{"*"}, "", ""},
+		{[]string{"https://example.com"}, "https://example.com", "https://example.com"},
+		{[]string{"https://example.com"}, "https://example.org", ""},
+		{[]string{"https://example.com"}, "http://example.com", ""},
+		{[]string{"https://*.example.com"}, "https://cdn.example.com", "https://cdn.example.com"},
+		{[]string{"https://*.example.com"}, "https://example.com", ""},
+		{[]string{"https://example.com", "*"}, "https://example.org", "*"},
+		{[]string{"not an origin", "https://example.com"}, "https://example.com", "https://example.com"},
+	}
+
+	for _, test := range tests {
+		for _, build := range []bool{false, true} {
+			o := cors.NewOptions()
+			o.TimingAllowOrigins = test.origins
+
+			if build {
+				o.NewHandler()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+			if test.origin != "" {
+				req.Header.Set(cors.HeaderOrigin, test.origin)
+			}
+
+			require.Equal(t, test.expected, o.GetTimingAllowOrigin((*cors.Request)(req)), "%v %q, built %t", test.origins, test.origin, build)
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_TimingAllowOrigin(t *testing.T) {
+	o := cors.NewOptions()
+	o.AllowOrigins = []string{"https://app.example.com"}
+	o.AllowMethods = []string{http.MethodPut}
+	o.TimingAllowOrigins = []string{"https://*.example.com"}
+
+	h := o.NewHandler()
+
+	serve := func(method, origin string) http.Header {
+		req := httptest.NewRequest(method, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		if method == http.MethodOptions {
+			req.Header.Set(cors.HeaderRequestMethod, http.MethodPut)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Header()
+	}
+
+	// Allowed for both CORS and timings.
+	header := serve(http.MethodGet, "https://app.example.com")
+	require.Equal(t, "https://app.example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "https://app.example.com", header.Get(cors.HeaderTimingAllowOrigin))
+	require.Equal(t, cors.HeaderOrigin, header.Get(cors.HeaderVary))
+
+	// Timings are allowed to an origin denied by CORS.
+	header = serve(http.MethodGet, "https://dashboard.example.com")
+	require.Empty(t, header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "https://dashboard.example.com", header.Get(cors.HeaderTimingAllowOrigin))
+
+	header = serve(http.MethodGet, "https://example.org")
+	require.Empty(t, header.Get(cors.HeaderAllowOrigin))
+	require.Empty(t, header.Values(cors.HeaderTimingAllowOrigin))
+
+	// Preflight responses never carry the header.
+	header = serve(http.MethodOptions, "https://app.example.com")
+	require.Equal(t, "https://app.example.com", header.Get(cors.HeaderAllowOrigin))
+	require.Empty(t, header.Values(cors.HeaderTimingAllowOrigin))
+
+	// The wildcard timing origin does not make the response vary.
+	o = cors.NewOptions()
+	o.AllowOrigins = []string{cors.HeaderValueWildcard}
+	o.TimingAllowOrigins = []string{cors.HeaderValueWildcard}
+	h = o.NewHandler()
+
+	header = serve(http.MethodGet, "https://example.org")
+	require.Equal(t, "*", header.Get(cors.HeaderTimingAllowOrigin))
+	require.Empty(t, header.Values(cors.HeaderVary))
+}
+
+func TestOptions_Validate_TimingAllowOrigins(t *testing.T) {
+	o := cors.NewOptions()
+	o.TimingAllowOrigins = []string{"*", "https://*.example.com"}
+	require.NoError(t, o.Validate())
+
+	o.TimingAllowOrigins = []string{"https://example.com/path"}
+	require.True(t, errors.Is(o.Validate(), cors.ErrInvalidOrigin))
+}
@@ -0,0 +1,94 @@
+package cors
+
+import (
+	"fmt"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
+)
+
+// Warnings reports likely mistakes that do not prevent the Options from
+// working: entries of AllowOrigins duplicating another one once normalized,
+// such as "https://Example.com" and "https://example.com:443", entries that
+// can never make a difference because "*" or a pattern already allows them,
+// a MaxAge clamped to MaxAgeCeiling, an ExposeHeaders wildcard exposing
+// nothing to requests with credentials and valid AllowMethods that are not
+// known methods, such as "DELTE". Invalid entries are reported by
+// Validate instead. The warnings are meant to be logged by the caller.
+func (o *Options) Warnings() []string {
+	warnings := o.originWarnings()
+
+	if maxAge := o.maxAge(); o.MaxAgeCeiling > 0 && maxAge > o.MaxAgeCeiling {
+		warnings = append(warnings, fmt.Sprintf("max age %d exceeds the ceiling browsers apply and is clamped to %d",
+			maxAge, o.MaxAgeCeiling))
+	}
+
+	for _, m := range o.allowMethods() {
+		if _, ok := knownMethods[m]; !ok && m != HeaderValueWildcard && IsMethod(m) {
+			warnings = append(warnings, fmt.Sprintf("allowed method %q is not a known method", m))
+		}
+	}
+
+	if o.AllowCredentials && o.exposesWildcard() && len(o.credentialExposeHeaders()) == 1 {
+		warnings = append(warnings, fmt.Sprintf("exposed header %q is not honored for requests with credentials: "+
+			"list the headers to expose to them in ExposeHeadersCredentialFallback", HeaderValueWildcard))
+	}
+
+	return warnings
+}
+
+// originWarnings returns the warnings about AllowOrigins.
+func (o *Options) originWarnings() []string {
+	var warnings []string
+
+	seen := make(map[string]string, len(o.AllowOrigins))
+	patterns := make([]Pattern, 0, len(o.AllowOrigins))
+	literals := make([]Pattern, 0, len(o.AllowOrigins))
+	wildcard := false
+
+	for _, ao := range o.AllowOrigins {
+		if ao = normalize.TrimOWS(ao); ao == "" {
+			continue
+		}
+
+		key := ao
+
+		if ao == HeaderValueWildcard {
+			wildcard = true
+		} else if ao != OriginSelf {
+			p, err := o.compile(ao)
+			if err != nil {
+				continue
+			}
+
+			key = p.key()
+
+			if p.IsLiteral() {
+				literals = append(literals, p)
+			} else {
+				patterns = append(patterns, p)
+			}
+		}
+
+		if first, ok := seen[key]; ok {
+			warnings = append(warnings, fmt.Sprintf("allowed origin %q duplicates %q", ao, first))
+		} else {
+			seen[key] = ao
+		}
+	}
+
+	if wildcard && len(seen) > 1 {
+		return append(warnings, fmt.Sprintf("allowed origins other than %q are shadowed by it", HeaderValueWildcard))
+	}
+
+	for _, l := range literals {
+		for _, p := range patterns {
+			if p.matchOrigin(Origin{Scheme: l.scheme, Host: l.host, Port: l.port}) {
+				warnings = append(warnings, fmt.Sprintf("allowed origin %q is shadowed by pattern %q", l, p))
+
+				break
+			}
+		}
+	}
+
+	return warnings
+}
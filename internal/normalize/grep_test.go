@@ -0,0 +1,55 @@
+package normalize_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// commaSplit matches ad hoc splitting of comma-separated values, by separator
+// string or by searching for the comma byte, which must go through SplitList,
+// CountList or First instead.
+var commaSplit = regexp.MustCompile(`strings\.Split\w*\([^\n]*,\s*", ?"|strings\.(?:Last)?IndexByte\([^\n]*,\s*','\)`)
+
+// TestNoCommaSplitOutsideNormalize keeps every comma list parser of the module
+// in this package, so they cannot disagree.
+func TestNoCommaSplitOutsideNormalize(t *testing.T) {
+	self, err := filepath.Abs(".")
+	require.Nil(t, err)
+
+	root := filepath.Join(self, "..", "..")
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path == self || strings.HasPrefix(info.Name(), ".") || info.Name() == "vendor" {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		require.Empty(t, commaSplit.FindAllString(string(b), -1), "%s splits a comma list; use normalize.SplitList or normalize.CountList", rel)
+
+		return nil
+	})
+	require.Nil(t, err)
+}
@@ -0,0 +1,175 @@
+// Package normalize holds the string primitives shared by the parsers,
+// matchers and validators of the module, so that they all agree on what a
+// header value means.
+package normalize
+
+import (
+	"net/textproto"
+	"strings"
+)
+
+// TrimOWS removes the optional whitespace (spaces and horizontal tabs)
+// surrounding s.
+// See: RFC7230 § 3.2.3. Whitespace.
+func TrimOWS(s string) string {
+	start, end := 0, len(s)
+
+	for start < end && isOWS(s[start]) {
+		start++
+	}
+
+	for end > start && isOWS(s[end-1]) {
+		end--
+	}
+
+	return s[start:end]
+}
+
+func isOWS(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// LowerASCII returns s with ASCII letters lower-cased. It does not allocate
+// when s is already lower case. Other bytes are left untouched.
+func LowerASCII(s string) string {
+	i := 0
+	for i < len(s) && !('A' <= s[i] && s[i] <= 'Z') {
+		i++
+	}
+
+	if i == len(s) {
+		return s
+	}
+
+	b := []byte(s)
+	for ; i < len(b); i++ {
+		if 'A' <= b[i] && b[i] <= 'Z' {
+			b[i] += 'a' - 'A'
+		}
+	}
+
+	return string(b)
+}
+
+// SplitList splits a comma-separated header value into its elements with
+// their optional whitespace removed, skipping empty elements. At most max
+// elements are returned; ok is false when s holds more. A negative max means
+// no limit.
+// See: RFC7230 § 7. ABNF List Extension: #rule.
+func SplitList(s string, max int) (elements []string, ok bool) {
+	for len(s) > 0 {
+		var e string
+
+		if e, s = cut(s); e == "" {
+			continue
+		}
+
+		if max >= 0 && len(elements) == max {
+			return elements, false
+		}
+
+		elements = append(elements, e)
+	}
+
+	return elements, true
+}
+
+// CountList counts the non-empty elements of a comma-separated header value
+// like SplitList, without allocating. It stops counting past max, so it is
+// cheap on hostile lists: n is then max+1 and ok is false. A negative max means
+// no limit.
+func CountList(s string, max int) (n int, ok bool) {
+	for len(s) > 0 {
+		var e string
+
+		if e, s = cut(s); e == "" {
+			continue
+		}
+
+		if n++; max >= 0 && n > max {
+			return n, false
+		}
+	}
+
+	return n, true
+}
+
+// cut returns the first element of the comma-separated s, with its optional
+// whitespace removed, and the rest of s.
+func cut(s string) (e, rest string) {
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		return TrimOWS(s[:i]), s[i+1:]
+	}
+
+	return TrimOWS(s), ""
+}
+
+// List returns the entries of a configured list with their optional
+// whitespace removed, skipping empty entries and repeated ones, in order.
+// values itself is left untouched.
+func List(values []string) []string {
+	list := make([]string, 0, len(values))
+
+	for _, v := range values {
+		if v = TrimOWS(v); v == "" || contains(list, v) {
+			continue
+		}
+
+		list = append(list, v)
+	}
+
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// First returns the first element of a comma-separated header value, or an
+// empty string.
+func First(s string) string {
+	elements, _ := SplitList(s, 1)
+	if len(elements) == 0 {
+		return ""
+	}
+
+	return elements[0]
+}
+
+// CanonicalHeader returns the canonical form of the header name s, such as
+// "Content-Type" for "content-type".
+func CanonicalHeader(s string) string {
+	return textproto.CanonicalMIMEHeaderKey(s)
+}
+
+// IsToken reports whether s is a non-empty token, as used by method and
+// header names.
+// See: RFC7230 § 3.2.6. Field Value Components.
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if !isTchar(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isTchar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
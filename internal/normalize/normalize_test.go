@@ -0,0 +1,232 @@
+package normalize_test
+
+import (
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrimOWS(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		" \t ":          "",
+		"\tgzip ":       "gzip",
+		"a b":           "a b",
+		"\r\nvalue\r\n": "\r\nvalue\r\n",
+	}
+
+	for in, expected := range tests {
+		require.Equal(t, expected, normalize.TrimOWS(in), "%q", in)
+	}
+}
+
+func TestLowerASCII(t *testing.T) {
+	tests := map[string]string{
+		"":             "",
+		"content-type": "content-type",
+		"Content-Type": "content-type",
+		"ÄBC":          "Äbc",
+	}
+
+	for in, expected := range tests {
+		require.Equal(t, expected, normalize.LowerASCII(in), "%q", in)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in       string
+		max      int
+		expected []string
+		ok       bool
+	}{
+		{"", -1, nil, true},
+		{" , ,, ", -1, nil, true},
+		{"a", -1, []string{"a"}, true},
+		{"a, b,c ,\td", -1, []string{"a", "b", "c", "d"}, true},
+		{"a,,b", 2, []string{"a", "b"}, true},
+		{"a,b,c", 2, []string{"a", "b"}, false},
+		{"a", 0, nil, false},
+	}
+
+	for _, tt := range tests {
+		elements, ok := normalize.SplitList(tt.in, tt.max)
+		require.Equal(t, tt.expected, elements, "%q", tt.in)
+		require.Equal(t, tt.ok, ok, "%q", tt.in)
+	}
+}
+
+func TestCountList(t *testing.T) {
+	tests := []struct {
+		in       string
+		max      int
+		expected int
+		ok       bool
+	}{
+		{"", -1, 0, true},
+		{" , ,, ", 0, 0, true},
+		{"a, b,c ,\td", -1, 4, true},
+		{"a,,b", 2, 2, true},
+		{"a,b,c,d", 2, 3, false},
+		{"a", 0, 1, false},
+	}
+
+	for _, tt := range tests {
+		n, ok := normalize.CountList(tt.in, tt.max)
+		require.Equal(t, tt.expected, n, "%q", tt.in)
+		require.Equal(t, tt.ok, ok, "%q", tt.in)
+	}
+}
+
+func TestFirst(t *testing.T) {
+	require.Equal(t, "", normalize.First(""))
+	require.Equal(t, "https", normalize.First(" https , http"))
+	require.Equal(t, "http", normalize.First(", http"))
+}
+
+func TestList(t *testing.T) {
+	values := []string{" GET", "POST ", "", "\t", "GET", "get"}
+	require.Equal(t, []string{"GET", "POST", "get"}, normalize.List(values))
+	require.Equal(t, []string{" GET", "POST ", "", "\t", "GET", "get"}, values)
+	require.Equal(t, []string{}, normalize.List(nil))
+}
+
+func TestCanonicalHeader(t *testing.T) {
+	require.Equal(t, "Content-Type", normalize.CanonicalHeader("content-TYPE"))
+	require.Equal(t, "X-Request-Id", normalize.CanonicalHeader("x-request-id"))
+}
+
+func TestIsToken(t *testing.T) {
+	for _, s := range []string{"GET", "x-custom_header", "a!#$%&'*+-.^_`|~z"} {
+		require.True(t, normalize.IsToken(s), s)
+	}
+
+	for _, s := range []string{"", "a b", "a,b", "a:b", "é", "a\r\n"} {
+		require.False(t, normalize.IsToken(s), s)
+	}
+}
+
+// The properties below are checked against random inputs.
+
+func TestSplitList_Properties(t *testing.T) {
+	property := func(s string, max int8) bool {
+		elements, ok := normalize.SplitList(s, int(max))
+
+		if max >= 0 && len(elements) > int(max) {
+			return false
+		}
+
+		for _, e := range elements {
+			if e == "" || strings.Contains(e, ",") || normalize.TrimOWS(e) != e {
+				return false
+			}
+		}
+
+		all, _ := normalize.SplitList(s, -1)
+
+		return ok == (len(all) == len(elements))
+	}
+
+	require.Nil(t, quick.Check(property, nil))
+}
+
+func TestCountList_Properties(t *testing.T) {
+	property := func(s string, max int8) bool {
+		n, ok := normalize.CountList(s, int(max))
+		elements, within := normalize.SplitList(s, int(max))
+
+		return ok == within && (!ok || n == len(elements))
+	}
+
+	require.Nil(t, quick.Check(property, nil))
+}
+
+func TestLowerASCII_Properties(t *testing.T) {
+	property := func(s string) bool {
+		lower := normalize.LowerASCII(s)
+
+		return len(lower) == len(s) && normalize.LowerASCII(lower) == lower &&
+			strings.EqualFold(lower, s) == strings.EqualFold(strings.ToLower(s), s)
+	}
+
+	require.Nil(t, quick.Check(property, nil))
+}
+
+func TestTrimOWS_Properties(t *testing.T) {
+	property := func(s string) bool {
+		trimmed := normalize.TrimOWS(s)
+
+		return normalize.TrimOWS(trimmed) == trimmed && strings.Contains(s, trimmed)
+	}
+
+	require.Nil(t, quick.Check(property, nil))
+}
+
+func TestAllocations(t *testing.T) {
+	allocs := map[string]func(){
+		"TrimOWS":           func() { normalize.TrimOWS(" \tgzip ") },
+		"LowerASCII lower":  func() { normalize.LowerASCII("content-type") },
+		"First":             func() { normalize.First(" https , http") },
+		"IsToken":           func() { normalize.IsToken("x-custom-header") },
+		"SplitList one":     func() { normalize.SplitList("a", 1) },
+		"SplitList nothing": func() { normalize.SplitList(" , ", -1) },
+	}
+
+	for name, f := range allocs {
+		require.LessOrEqual(t, testing.AllocsPerRun(100, f), float64(1), name)
+	}
+
+	require.Zero(t, testing.AllocsPerRun(100, func() { normalize.LowerASCII("content-type") }))
+	require.Zero(t, testing.AllocsPerRun(100, func() { normalize.TrimOWS(" a ") }))
+}
+
+func BenchmarkTrimOWS(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		normalize.TrimOWS(" \tcontent-type\t ")
+	}
+}
+
+func BenchmarkLowerASCII_Lower(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		normalize.LowerASCII("content-type")
+	}
+}
+
+func BenchmarkLowerASCII_Mixed(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		normalize.LowerASCII("Content-Type")
+	}
+}
+
+func BenchmarkSplitList(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		normalize.SplitList("content-type, authorization, x-request-id", 64)
+	}
+}
+
+func BenchmarkCanonicalHeader(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		normalize.CanonicalHeader("content-type")
+	}
+}
+
+func BenchmarkIsToken(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		normalize.IsToken("x-request-id")
+	}
+}
@@ -0,0 +1,214 @@
+package traefik
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/quintinheard/traefik-cors/internal/normalize"
+)
+
+// hostConfig returns the configuration of a Hosts entry: the top-level
+// configuration, overridden by the fields the entry sets. Traefik decodes
+// nested structures into zero values, so entries are decoded here instead.
+// Fields are named as in the top-level configuration, case-insensitively, and
+// values may be strings, as they are in labels, or typed, as they are in
+// files. Unknown fields and nested hosts are rejected. An entry setting
+// AllowHeaders, AllowMethods or AllowOrigins does not inherit the matching
+// AllowAll field, which would conflict with it.
+func hostConfig(top *Config, entry map[string]interface{}) (*Config, error) {
+	config := *top
+	config.Hosts = nil
+	config.UnknownHosts = ""
+
+	v := reflect.ValueOf(&config).Elem()
+	fields := configFields(v.Type())
+
+	keys := make([]string, 0, len(entry))
+	for k := range entry {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := strings.ToLower(key)
+		if name == "hosts" || name == "unknownhosts" {
+			return nil, fmt.Errorf("%s: hosts cannot be nested", key)
+		}
+
+		i, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown field", key)
+		}
+
+		if err := setField(v.Field(i), entry[key]); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	for list, all := range map[string]*bool{
+		"allowheaders": &config.AllowAllHeaders,
+		"allowmethods": &config.AllowAllMethods,
+		"alloworigins": &config.AllowAllOrigins,
+	} {
+		if sets(entry, list) && !sets(entry, "allowall"+strings.TrimPrefix(list, "allow")) {
+			*all = false
+		}
+	}
+
+	return &config, nil
+}
+
+// sets reports whether entry sets the field of the lower case name.
+func sets(entry map[string]interface{}, name string) bool {
+	for k := range entry {
+		if strings.ToLower(k) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configFields maps the lower case json names of the fields of Config to
+// their index.
+func configFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.TrimSuffix(t.Field(i).Tag.Get("json"), ",omitempty")
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = i
+		}
+	}
+
+	return fields
+}
+
+var errInvalidValue = errors.New("invalid value")
+
+// setField sets field to value, converting strings to the type of field.
+func setField(field reflect.Value, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.Bool:
+		switch b := value.(type) {
+		case bool:
+			field.SetBool(b)
+		case string:
+			parsed, err := strconv.ParseBool(normalize.TrimOWS(b))
+			if err != nil {
+				return fmt.Errorf("%w %q: must be true or false", errInvalidValue, b)
+			}
+
+			field.SetBool(parsed)
+		default:
+			return fmt.Errorf("%w %v: must be true or false", errInvalidValue, value)
+		}
+	case reflect.Int, reflect.Int64:
+		n, err := toInt(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(n)
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w %v: must be a string", errInvalidValue, value)
+		}
+
+		field.SetString(s)
+	case reflect.Slice:
+		if field.Type() != reflect.TypeOf([]string{}) {
+			return fmt.Errorf("%w %v: unsupported field", errInvalidValue, value)
+		}
+
+		list, err := toList(value)
+		if err != nil {
+			return err
+		}
+
+		field.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w %v: must be a map", errInvalidValue, value)
+		}
+
+		if field.Type() != reflect.TypeOf(map[string][]string{}) {
+			return fmt.Errorf("%w %v: unsupported field", errInvalidValue, value)
+		}
+
+		lists := make(map[string][]string, len(m))
+
+		for k, v := range m {
+			list, err := toList(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+
+			lists[k] = list
+		}
+
+		field.Set(reflect.ValueOf(lists))
+	default:
+		return fmt.Errorf("%w %v: unsupported field", errInvalidValue, value)
+	}
+
+	return nil
+}
+
+// toInt converts a number, or a string holding one, to an int64.
+func toInt(value interface{}) (int64, error) {
+	switch n := value.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), nil
+		}
+	case string:
+		if parsed, err := strconv.ParseInt(normalize.TrimOWS(n), 10, 64); err == nil {
+			return parsed, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w %v: must be an integer", errInvalidValue, value)
+}
+
+// toList converts a list, or a comma-separated string, to a list of strings.
+func toList(value interface{}) ([]string, error) {
+	switch l := value.(type) {
+	case []string:
+		return l, nil
+	case string:
+		elements, _ := normalize.SplitList(l, -1)
+
+		return append([]string{}, elements...), nil
+	case []interface{}:
+		list := make([]string, 0, len(l))
+
+		for _, e := range l {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w %v: must be a list of strings", errInvalidValue, value)
+			}
+
+			list = append(list, s)
+		}
+
+		return list, nil
+	}
+
+	return nil, fmt.Errorf("%w %v: must be a list of strings", errInvalidValue, value)
+}
@@ -3,7 +3,13 @@ package traefik
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/quintinheard/traefik-cors/cors"
 )
@@ -16,8 +22,109 @@ type Config struct {
 	AllowOrigins     []string `json:"allowOrigins,omitempty"`
 	ExposeHeaders    []string `json:"exposeHeaders,omitempty"`
 	MaxAge           int      `json:"maxAge,omitempty"`
+	MaxAgeCeiling    int      `json:"maxAgeCeiling,omitempty"`
+	MaxAgeDuration   string   `json:"maxAgeDuration,omitempty"`
+	AllowLocalhost   bool     `json:"allowLocalhost,omitempty"`
+	AllowOriginCIDRs []string `json:"allowOriginCIDRs,omitempty"`
+
+	// AllowAllHeaders, AllowAllMethods and AllowAllOrigins set AllowHeaders,
+	// AllowMethods and AllowOrigins to the wildcard, which is easier to write
+	// in labels than a list. The list must then be left to its default.
+	AllowAllHeaders bool `json:"allowAllHeaders,omitempty"`
+	AllowAllMethods bool `json:"allowAllMethods,omitempty"`
+	AllowAllOrigins bool `json:"allowAllOrigins,omitempty"`
+
+	AllowDomains              []string `json:"allowDomains,omitempty"`
+	AllowDomainsAnyScheme     bool     `json:"allowDomainsAnyScheme,omitempty"`
+	AllowPublicSuffixPatterns bool     `json:"allowPublicSuffixPatterns,omitempty"`
+	AllowExtensionIDs         []string `json:"allowExtensionIDs,omitempty"`
+	TimingAllowOrigins        []string `json:"timingAllowOrigins,omitempty"`
+	ResourcePolicy            string   `json:"resourcePolicy,omitempty"`
+	SelfScheme                string   `json:"selfScheme,omitempty"`
+	TrustedProxies            []string `json:"trustedProxies,omitempty"`
+	SuppressSameOriginHeaders bool     `json:"suppressSameOriginHeaders,omitempty"`
+	OriginCacheSize           int      `json:"originCacheSize,omitempty"`
+	DeniedOriginCacheSize     int      `json:"deniedOriginCacheSize,omitempty"`
+	DeniedOriginCacheTTL      int      `json:"deniedOriginCacheTTL,omitempty"`
+	PreflightCacheSize        int      `json:"preflightCacheSize,omitempty"`
+	HeaderListSeparator       string   `json:"headerListSeparator,omitempty"`
+	VaryExtra                 []string `json:"varyExtra,omitempty"`
+	SkipContentTypes          []string `json:"skipContentTypes,omitempty"`
+	StrictMode                bool     `json:"strictMode,omitempty"`
+	StrictModeStatus          int      `json:"strictModeStatus,omitempty"`
+	RequireSecureOrigins      bool     `json:"requireSecureOrigins,omitempty"`
+	MaxOriginLength           int      `json:"maxOriginLength,omitempty"`
+	ParanoidChecks            bool     `json:"paranoidChecks,omitempty"`
+	PassthroughPreflight      bool     `json:"passthroughPreflight,omitempty"`
+	LenientPreflight          bool     `json:"lenientPreflight,omitempty"`
+	PreserveExistingHeaders   bool     `json:"preserveExistingHeaders,omitempty"`
+	HeaderPhase               string   `json:"headerPhase,omitempty"`
+	StripUpstreamCORSHeaders  bool     `json:"stripUpstreamCorsHeaders,omitempty"`
+	StripManagedHeadersOnly   bool     `json:"stripManagedHeadersOnly,omitempty"`
+	OptionsPassthrough        bool     `json:"optionsPassthrough,omitempty"`
+	StripOriginHeader         bool     `json:"stripOriginHeader,omitempty"`
+	StripRequestHeaders       bool     `json:"stripRequestHeaders,omitempty"`
+	ForwardOrigin             bool     `json:"forwardOrigin,omitempty"`
+	ForwardOriginHeader       string   `json:"forwardOriginHeader,omitempty"`
+	DebugHeader               string   `json:"debugHeader,omitempty"`
+
+	EnforceMethods                   bool  `json:"enforceMethods,omitempty"`
+	IncludeDefaultMethods            bool  `json:"includeDefaultMethods,omitempty"`
+	ReflectRequestMethods            bool  `json:"reflectRequestMethods,omitempty"`
+	EnforceHeaders                   bool  `json:"enforceHeaders,omitempty"`
+	IncludeSafelistedHeaders         bool  `json:"includeSafelistedHeaders,omitempty"`
+	IncludeAuthorizationWithWildcard bool  `json:"includeAuthorizationWithWildcard,omitempty"`
+	ReflectRequestHeaders            bool  `json:"reflectRequestHeaders,omitempty"`
+	PartialAllowHeaders              bool  `json:"partialAllowHeaders,omitempty"`
+	PreflightStatus                  int   `json:"preflightStatus,omitempty"`
+	DeniedPreflightStatus            int   `json:"deniedPreflightStatus,omitempty"`
+	PreflightFailureStatus           int   `json:"preflightFailureStatus,omitempty"`
+	ExplainDeniedPreflights          bool  `json:"explainDeniedPreflights,omitempty"`
+	Debug                            bool  `json:"debug,omitempty"`
+	MaxRequestedHeaders              int   `json:"maxRequestedHeaders,omitempty"`
+	MaxPreflightHeaderCount          int   `json:"maxPreflightHeaderCount,omitempty"`
+	PreflightHeaderCountStatus       int   `json:"preflightHeaderCountStatus,omitempty"`
+	MaxPreflightBodyBytes            int64 `json:"maxPreflightBodyBytes,omitempty"`
+	PreflightBodyStatus              int   `json:"preflightBodyStatus,omitempty"`
+
+	// AllowHeadersPreset and ExposeHeadersPreset name a built-in header list
+	// (see cors.HeaderPreset) merged into AllowHeaders and ExposeHeaders.
+	AllowHeadersPreset  string `json:"allowHeadersPreset,omitempty"`
+	ExposeHeadersPreset string `json:"exposeHeadersPreset,omitempty"`
+
+	// AllowHeadersByMethod maps methods to the headers allowed to their
+	// preflight requests in place of AllowHeaders.
+	AllowHeadersByMethod map[string][]string `json:"allowHeadersByMethod,omitempty"`
+
+	// ExposeDownloadHeaders merges cors.ExposeDownloadHeaders into
+	// ExposeHeaders.
+	ExposeDownloadHeaders bool `json:"exposeDownloadHeaders,omitempty"`
+
+	// ExposeHeadersCredentialFallback lists the headers exposed to requests
+	// with credentials when ExposeHeaders is the wildcard.
+	ExposeHeadersCredentialFallback []string `json:"exposeHeadersCredentialFallback,omitempty"`
+	KeepForbiddenExposeHeaders      bool     `json:"keepForbiddenExposeHeaders,omitempty"`
+	AutoReflect                     bool     `json:"autoReflect,omitempty"`
+
+	// Hosts maps a host name, or a wildcard suffix such as "*.example.com",
+	// to the configuration used for requests to that host (see
+	// cors.HostOptions): the fields it sets, by name, over the top-level
+	// configuration. Requests to other hosts use the top-level configuration,
+	// or are passed on untouched when UnknownHosts is UnknownHostsPassthrough.
+	Hosts        map[string]map[string]interface{} `json:"hosts,omitempty"`
+	UnknownHosts string                            `json:"unknownHosts,omitempty"`
 }
 
+// Values of Config.UnknownHosts.
+const (
+	// UnknownHostsDefault applies the top-level configuration to hosts not
+	// listed in Config.Hosts.
+	UnknownHostsDefault = "default"
+	// UnknownHostsPassthrough passes requests to hosts not listed in
+	// Config.Hosts on without any CORS processing.
+	UnknownHostsPassthrough = "passthrough"
+)
+
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{
@@ -27,40 +134,347 @@ func CreateConfig() *Config {
 		AllowOrigins:     []string{"*"},
 		ExposeHeaders:    []string{},
 		MaxAge:           cors.DefaultMaxAge,
+		MaxAgeCeiling:    cors.DefaultMaxAgeCeiling,
+		MaxAgeDuration:   "",
+		AllowLocalhost:   false,
+		AllowOriginCIDRs: []string{},
+
+		AllowAllHeaders: false,
+		AllowAllMethods: false,
+		AllowAllOrigins: false,
+
+		AllowDomains:              []string{},
+		AllowDomainsAnyScheme:     false,
+		AllowPublicSuffixPatterns: false,
+		AllowExtensionIDs:         []string{},
+		TimingAllowOrigins:        []string{},
+		ResourcePolicy:            "",
+		SelfScheme:                "",
+		TrustedProxies:            []string{},
+		SuppressSameOriginHeaders: false,
+		OriginCacheSize:           cors.DefaultOriginCacheSize,
+		DeniedOriginCacheSize:     cors.DefaultDeniedOriginCacheSize,
+		DeniedOriginCacheTTL:      int(cors.DefaultDeniedOriginCacheTTL / time.Second),
+		PreflightCacheSize:        0,
+		HeaderListSeparator:       cors.ListSeparator,
+		VaryExtra:                 []string{},
+		SkipContentTypes:          []string{cors.ContentTypeGRPC},
+		StrictMode:                false,
+		StrictModeStatus:          http.StatusInternalServerError,
+		RequireSecureOrigins:      false,
+		MaxOriginLength:           cors.DefaultMaxOriginLength,
+		ParanoidChecks:            false,
+		PassthroughPreflight:      false,
+		LenientPreflight:          false,
+		PreserveExistingHeaders:   false,
+		HeaderPhase:               cors.HeaderPhaseRequest,
+		StripUpstreamCORSHeaders:  false,
+		StripManagedHeadersOnly:   false,
+		OptionsPassthrough:        true,
+		StripOriginHeader:         false,
+		StripRequestHeaders:       false,
+		ForwardOrigin:             false,
+		ForwardOriginHeader:       cors.DefaultForwardOriginHeader,
+		DebugHeader:               cors.DefaultDebugHeader,
+
+		EnforceMethods:                   false,
+		IncludeDefaultMethods:            true,
+		ReflectRequestMethods:            false,
+		EnforceHeaders:                   false,
+		IncludeSafelistedHeaders:         true,
+		IncludeAuthorizationWithWildcard: false,
+		ReflectRequestHeaders:            false,
+		PartialAllowHeaders:              false,
+		PreflightStatus:                  http.StatusNoContent,
+		DeniedPreflightStatus:            http.StatusNoContent,
+		PreflightFailureStatus:           http.StatusForbidden,
+		ExplainDeniedPreflights:          false,
+		Debug:                            false,
+		MaxRequestedHeaders:              cors.DefaultMaxRequestedHeaders,
+		MaxPreflightHeaderCount:          cors.DefaultMaxPreflightHeaderCount,
+		PreflightHeaderCountStatus:       http.StatusRequestHeaderFieldsTooLarge,
+		MaxPreflightBodyBytes:            cors.DefaultMaxPreflightBodyBytes,
+		PreflightBodyStatus:              http.StatusRequestEntityTooLarge,
+
+		AllowHeadersPreset:  "",
+		ExposeHeadersPreset: "",
+
+		AllowHeadersByMethod: map[string][]string{},
+
+		ExposeDownloadHeaders: false,
+
+		ExposeHeadersCredentialFallback: []string{},
+		KeepForbiddenExposeHeaders:      false,
+		AutoReflect:                     false,
+
+		Hosts:        map[string]map[string]interface{}{},
+		UnknownHosts: UnknownHostsDefault,
 	}
 }
 
 // CorsPlugin a Traefik plugin.
 type CorsPlugin struct {
-	next http.Handler
 	name string
 	cors http.Handler
 }
 
 // New create a new CORS plugin.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	c := &cors.Options{
-		AllowCredentials: config.AllowCredentials,
-		AllowHeaders:     config.AllowHeaders,
-		AllowMethods:     config.AllowMethods,
-		AllowOrigins:     config.AllowOrigins,
-		ExposeHeaders:    config.ExposeHeaders,
-		MaxAge:           config.MaxAge,
+	c, err := config.options(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if len(config.Hosts) == 0 {
+		return &CorsPlugin{
+			name: name,
+			cors: c.NewMiddleware(next),
+		}, nil
+	}
+
+	hosts := &cors.HostOptions{
+		Hosts:   make(map[string]*cors.Options, len(config.Hosts)),
+		Default: c,
+	}
+
+	switch config.UnknownHosts {
+	case "", UnknownHostsDefault:
+	case UnknownHostsPassthrough:
+		hosts.Default = nil
+	default:
+		return nil, fmt.Errorf("%s: unknownHosts: must be %q or %q", name, UnknownHostsDefault, UnknownHostsPassthrough)
+	}
+
+	for _, host := range sortedKeys(config.Hosts) {
+		entry, err := hostConfig(config, config.Hosts[host])
+		if err != nil {
+			return nil, fmt.Errorf("%s: host %q: %w", name, host, err)
+		}
+
+		if hosts.Hosts[host], err = entry.options(fmt.Sprintf("%s: host %q", name, host)); err != nil {
+			return nil, fmt.Errorf("%s: host %q: %w", name, host, err)
+		}
+	}
+
+	if err := hosts.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
 
 	return &CorsPlugin{
-		next: next,
 		name: name,
-		cors: c.NewHandler(),
+		cors: hosts.NewMiddleware(next),
 	}, nil
 }
 
-func (c *CorsPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	c.cors.ServeHTTP(rw, req)
+// options returns the validated cors.Options of the configuration, logging
+// its warnings prefixed with name.
+func (config *Config) options(name string) (*cors.Options, error) {
+	if config == nil {
+		return nil, errors.New("missing configuration")
+	}
+
+	defaults := CreateConfig()
+
+	allowHeaders, err := allowAll(config.AllowAllHeaders, config.AllowHeaders, defaults.AllowHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("allowAllHeaders: %w", err)
+	}
+
+	allowMethods, err := allowAll(config.AllowAllMethods, config.AllowMethods, defaults.AllowMethods)
+	if err != nil {
+		return nil, fmt.Errorf("allowAllMethods: %w", err)
+	}
+
+	allowOrigins, err := allowAll(config.AllowAllOrigins, config.AllowOrigins, defaults.AllowOrigins)
+	if err != nil {
+		return nil, fmt.Errorf("allowAllOrigins: %w", err)
+	}
+
+	allowHeaders, err = withPreset(allowHeaders, config.AllowHeadersPreset)
+	if err != nil {
+		return nil, fmt.Errorf("allowHeadersPreset: %w", err)
+	}
+
+	exposeHeaders, err := withPreset(config.ExposeHeaders, config.ExposeHeadersPreset)
+	if err != nil {
+		return nil, fmt.Errorf("exposeHeadersPreset: %w", err)
+	}
+
+	if config.ExposeDownloadHeaders {
+		exposeHeaders = merge(exposeHeaders, cors.ExposeDownloadHeaders())
+	}
 
-	if (*cors.Request)(req).IsPreflight() {
-		return
+	var maxAgeDuration time.Duration
+	if config.MaxAgeDuration != "" {
+		if maxAgeDuration, err = time.ParseDuration(config.MaxAgeDuration); err != nil {
+			return nil, fmt.Errorf("maxAgeDuration: %w", err)
+		}
 	}
 
-	c.next.ServeHTTP(rw, req)
+	c := &cors.Options{
+		AllowCredentials: config.AllowCredentials,
+		AllowHeaders:     allowHeaders,
+		AllowMethods:     allowMethods,
+		AllowOrigins:     allowOrigins,
+		ExposeHeaders:    exposeHeaders,
+		MaxAge:           config.MaxAge,
+		MaxAgeCeiling:    config.MaxAgeCeiling,
+		MaxAgeDuration:   maxAgeDuration,
+		AllowLocalhost:   config.AllowLocalhost,
+		AllowOriginCIDRs: config.AllowOriginCIDRs,
+
+		AllowDomains:              config.AllowDomains,
+		AllowDomainsAnyScheme:     config.AllowDomainsAnyScheme,
+		AllowPublicSuffixPatterns: config.AllowPublicSuffixPatterns,
+		AllowExtensionIDs:         config.AllowExtensionIDs,
+		TimingAllowOrigins:        config.TimingAllowOrigins,
+		ResourcePolicy:            config.ResourcePolicy,
+		SelfScheme:                config.SelfScheme,
+		TrustedProxies:            config.TrustedProxies,
+		SuppressSameOriginHeaders: config.SuppressSameOriginHeaders,
+		OriginCacheSize:           config.OriginCacheSize,
+		DeniedOriginCacheSize:     config.DeniedOriginCacheSize,
+		DeniedOriginCacheTTL:      time.Duration(config.DeniedOriginCacheTTL) * time.Second,
+		PreflightCacheSize:        config.PreflightCacheSize,
+		HeaderListSeparator:       config.HeaderListSeparator,
+		VaryExtra:                 config.VaryExtra,
+		SkipContentTypes:          config.SkipContentTypes,
+		StrictMode:                config.StrictMode,
+		StrictModeStatus:          config.StrictModeStatus,
+		ErrorLog:                  log.New(log.Writer(), name+": ", log.Flags()|log.Lmsgprefix),
+		RequireSecureOrigins:      config.RequireSecureOrigins,
+		MaxOriginLength:           config.MaxOriginLength,
+		ParanoidChecks:            config.ParanoidChecks,
+		PassthroughPreflight:      config.PassthroughPreflight,
+		LenientPreflight:          config.LenientPreflight,
+		PreserveExistingHeaders:   config.PreserveExistingHeaders,
+		HeaderPhase:               config.HeaderPhase,
+		StripUpstreamCORSHeaders:  config.StripUpstreamCORSHeaders,
+		StripManagedHeadersOnly:   config.StripManagedHeadersOnly,
+		OptionsPassthrough:        config.OptionsPassthrough,
+		StripOriginHeader:         config.StripOriginHeader,
+		StripRequestHeaders:       config.StripRequestHeaders,
+		ForwardOrigin:             config.ForwardOrigin,
+		ForwardOriginHeader:       config.ForwardOriginHeader,
+		DebugHeader:               config.DebugHeader,
+
+		EnforceMethods:                   config.EnforceMethods,
+		IncludeDefaultMethods:            config.IncludeDefaultMethods,
+		ReflectRequestMethods:            config.ReflectRequestMethods,
+		EnforceHeaders:                   config.EnforceHeaders,
+		IncludeSafelistedHeaders:         config.IncludeSafelistedHeaders,
+		IncludeAuthorizationWithWildcard: config.IncludeAuthorizationWithWildcard,
+		ReflectRequestHeaders:            config.ReflectRequestHeaders,
+		PartialAllowHeaders:              config.PartialAllowHeaders,
+		AllowHeadersByMethod:             config.AllowHeadersByMethod,
+		PreflightStatus:                  config.PreflightStatus,
+		DeniedPreflightStatus:            config.DeniedPreflightStatus,
+		PreflightFailureStatus:           config.PreflightFailureStatus,
+		ExplainDeniedPreflights:          config.ExplainDeniedPreflights,
+		Debug:                            config.Debug,
+		MaxRequestedHeaders:              config.MaxRequestedHeaders,
+		MaxPreflightHeaderCount:          config.MaxPreflightHeaderCount,
+		PreflightHeaderCountStatus:       config.PreflightHeaderCountStatus,
+		MaxPreflightBodyBytes:            config.MaxPreflightBodyBytes,
+		PreflightBodyStatus:              config.PreflightBodyStatus,
+
+		ExposeHeadersCredentialFallback: config.ExposeHeadersCredentialFallback,
+		KeepForbiddenExposeHeaders:      config.KeepForbiddenExposeHeaders,
+		AutoReflect:                     config.AutoReflect,
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, w := range c.Warnings() {
+		log.Printf("%s: %s", name, w)
+	}
+
+	return c, nil
+}
+
+// sortedKeys returns the keys of hosts in sorted order, so identical
+// configurations are always processed identically.
+func sortedKeys(hosts map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(hosts))
+	for k := range hosts {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// allowAll returns the wildcard when all is set, and list otherwise. Setting
+// all with a list other than empty, its default or the wildcard is an error.
+func allowAll(all bool, list, defaults []string) ([]string, error) {
+	if !all {
+		return list, nil
+	}
+
+	if len(list) > 0 && !equalLists(list, defaults) && !equalLists(list, []string{"*"}) {
+		return nil, fmt.Errorf("conflicts with the explicit list %q", list)
+	}
+
+	return []string{"*"}, nil
+}
+
+func equalLists(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withPreset returns a new list holding headers followed by the entries of
+// the named preset that are not already present. An empty preset name
+// returns headers unchanged.
+func withPreset(headers []string, preset string) ([]string, error) {
+	if preset == "" {
+		return headers, nil
+	}
+
+	p, ok := cors.HeaderPreset(preset)
+	if !ok {
+		return nil, fmt.Errorf("unknown header preset %q", preset)
+	}
+
+	return merge(headers, p), nil
+}
+
+// merge returns headers followed by the entries of extra it lacks, compared
+// case-insensitively, leaving headers unmodified.
+func merge(headers, extra []string) []string {
+	result := append([]string(nil), headers...)
+
+	for _, h := range extra {
+		if !containsFold(result, h) {
+			result = append(result, h)
+		}
+	}
+
+	return result
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *CorsPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	c.cors.ServeHTTP(rw, req)
 }
@@ -0,0 +1,543 @@
+package traefik_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quintinheard/traefik-cors/cors"
+	"github.com/quintinheard/traefik-cors/traefik"
+	"github.com/stretchr/testify/require"
+)
+
+var noop = http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+})
+
+func preflight(t *testing.T, h http.Handler, origin string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, origin)
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+	req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec.Result()
+}
+
+func TestNew_HeaderPresetSharedByInstances(t *testing.T) {
+	first := traefik.CreateConfig()
+	first.AllowHeadersPreset = cors.PresetStandardAPI
+
+	second := traefik.CreateConfig()
+	second.AllowHeaders = []string{"X-Tenant", "authorization"}
+	second.AllowHeadersPreset = cors.PresetStandardAPI
+
+	a, err := traefik.New(context.Background(), noop, first, "first")
+	require.Nil(t, err)
+
+	b, err := traefik.New(context.Background(), noop, second, "second")
+	require.Nil(t, err)
+
+	preset, ok := cors.HeaderPreset(cors.PresetStandardAPI)
+	require.True(t, ok)
+
+	resA := preflight(t, a, "https://example.com")
+	require.Nil(t, resA.Body.Close())
+
+	resB := preflight(t, b, "https://example.com")
+	require.Nil(t, resB.Body.Close())
+
+	expected := (&cors.Options{AllowHeaders: preset}).GetAllowHeaders()
+	require.Equal(t, expected, resA.Header.Get(cors.HeaderAllowHeaders))
+
+	// explicit entries come first and duplicates of the preset are skipped
+	require.Contains(t, resB.Header.Get(cors.HeaderAllowHeaders), "X-Tenant, Authorization, Accept")
+	require.Equal(t, 1, strings.Count(resB.Header.Get(cors.HeaderAllowHeaders), "Authorization"))
+
+	// the configuration of the first instance is left untouched
+	require.Equal(t, []string{}, first.AllowHeaders)
+}
+
+func TestNew_ExposeDownloadHeaders(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.AllowOrigins = []string{"https://example.com"}
+	config.ExposeHeaders = []string{"X-Checksum", "etag"}
+	config.ExposeHeadersPreset = cors.PresetPagination
+	config.ExposeDownloadHeaders = true
+
+	h, err := traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/files/1", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	// the operator's headers come first, then those of the presets, once each
+	require.Equal(t, "X-Checksum, Etag, Link, X-Total-Count, Content-Disposition, Content-Length, Content-Range, Accept-Ranges",
+		rec.Header().Get(cors.HeaderExposeHeaders))
+	require.Equal(t, []string{"X-Checksum", "etag"}, config.ExposeHeaders)
+	require.Equal(t, []string{"Content-Disposition", "Content-Length", "Content-Range", "Accept-Ranges", "ETag"},
+		cors.ExposeDownloadHeaders())
+}
+
+func TestNew_UnknownHeaderPreset(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.ExposeHeadersPreset = "does-not-exist"
+
+	_, err := traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+}
+
+func TestNew_InvalidMethod(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.AllowMethods = []string{http.MethodGet, "GET POST"}
+
+	_, err := traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+}
+
+func TestNew_PublicSuffixPattern(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.AllowOrigins = []string{"https://*.github.io"}
+
+	_, err := traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+
+	config.AllowPublicSuffixPatterns = true
+
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+}
+
+func TestCorsPlugin_ServeHTTP(t *testing.T) {
+	called := 0
+	next := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		called++
+
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	h, err := traefik.New(context.Background(), next, traefik.CreateConfig(), "cors")
+	require.Nil(t, err)
+
+	res := preflight(t, h, "https://example.com")
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	require.Nil(t, res.Body.Close())
+	require.Equal(t, 0, called)
+
+	req := httptest.NewRequest(http.MethodGet, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, called)
+	require.Equal(t, cors.HeaderValueWildcard, rec.Header().Get(cors.HeaderAllowOrigin))
+}
+
+func TestCorsPlugin_GRPCTrailers(t *testing.T) {
+	backend := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+		rw.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte{0, 0, 0, 0, 0})
+		rw.Header().Set("Grpc-Status", "0")
+		rw.Header().Set("Grpc-Message", "OK")
+	})
+
+	h, err := traefik.New(context.Background(), backend, traefik.CreateConfig(), "cors")
+	require.Nil(t, err)
+
+	srv := httptest.NewUnstartedServer(h)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+
+	defer srv.Close()
+
+	for _, ct := range []string{"application/grpc", "application/grpc+proto"} {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL+"/pkg.Service/Method", nil)
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", ct)
+		req.Header.Set("Te", "trailers")
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+		res, err := srv.Client().Do(req)
+		require.Nil(t, err)
+
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		require.Nil(t, err)
+		require.Nil(t, res.Body.Close())
+
+		require.Equal(t, 2, res.ProtoMajor)
+		require.Equal(t, "0", res.Trailer.Get("Grpc-Status"), ct)
+		require.Equal(t, "OK", res.Trailer.Get("Grpc-Message"), ct)
+		require.Equal(t, "", res.Header.Get(cors.HeaderAllowOrigin), ct)
+	}
+}
+
+func TestCorsPlugin_GRPCWebIsProcessed(t *testing.T) {
+	h, err := traefik.New(context.Background(), noop, traefik.CreateConfig(), "cors")
+	require.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "https://cors.example.com/pkg.Service/Method", nil)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, cors.HeaderValueWildcard, rec.Header().Get(cors.HeaderAllowOrigin))
+}
+
+func TestCorsPlugin_EmptyOriginIsAbsent(t *testing.T) {
+	for name, origin := range map[string][]string{
+		"absent":          nil,
+		"empty":           {""},
+		"whitespace only": {"  "},
+	} {
+		called := false
+		next := http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			called = true
+
+			rw.WriteHeader(http.StatusOK)
+		})
+
+		h, err := traefik.New(context.Background(), next, traefik.CreateConfig(), "cors")
+		require.Nil(t, err)
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header[cors.HeaderOrigin] = origin
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+		req.Header.Set(cors.HeaderRequestHeaders, "Content-Type")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.True(t, called, name)
+		require.Equal(t, http.StatusOK, rec.Code, name)
+	}
+}
+
+func TestNew_Hosts(t *testing.T) {
+	tenant := map[string]interface{}{"allowOrigins": []interface{}{"https://tenant.example.com"}}
+
+	config := traefik.CreateConfig()
+	config.AllowOrigins = []string{"https://example.com"}
+	config.Hosts = map[string]map[string]interface{}{"*.tenant.example.com": tenant}
+
+	serve := func(h http.Handler, host, origin string) string {
+		req := httptest.NewRequest(http.MethodGet, "https://"+host+"/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Header().Get(cors.HeaderAllowOrigin)
+	}
+
+	h, err := traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	require.Equal(t, "https://tenant.example.com", serve(h, "a.tenant.example.com", "https://tenant.example.com"))
+	require.Equal(t, "", serve(h, "a.tenant.example.com", "https://example.com"))
+	require.Equal(t, "https://example.com", serve(h, "api.example.com", "https://example.com"))
+
+	config.UnknownHosts = traefik.UnknownHostsPassthrough
+
+	h, err = traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+	require.Equal(t, "", serve(h, "api.example.com", "https://example.com"))
+
+	config.UnknownHosts = "deny"
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+
+	config.UnknownHosts = traefik.UnknownHostsDefault
+	tenant["allowOrigins"] = []interface{}{"https//tenant.example.com"}
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.Contains(t, err.Error(), `cors: host "*.tenant.example.com": allowed origin`)
+}
+
+func TestNew_HostsDefaults(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.Hosts = map[string]map[string]interface{}{
+		// as decoded from labels, where every value is a string
+		"api.example.com": {"AllowOrigins": "https://app.example.com, https://admin.example.com", "maxAge": "60"},
+		"*.example.com":   {"allowOrigins": []interface{}{"https://app.example.com"}, "optionsPassthrough": false},
+	}
+
+	var called int
+
+	h, err := traefik.New(context.Background(), http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called++ }), config, "cors")
+	require.Nil(t, err)
+
+	serve := func(method, host string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "https://"+host+"/api/", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// fields left out take the top-level values, here the defaults: OPTIONS
+	// requests that are not preflights are passed on, and methods are left to
+	// the browser
+	serve(http.MethodOptions, "api.example.com", nil)
+	require.Equal(t, 1, called)
+
+	rec := serve(http.MethodOptions, "api.example.com", map[string]string{
+		cors.HeaderOrigin:        "https://admin.example.com",
+		cors.HeaderRequestMethod: http.MethodDelete,
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.NotContains(t, rec.Header().Get(cors.HeaderAllowMethods), http.MethodDelete)
+
+	rec = serve(http.MethodOptions, "api.example.com", map[string]string{
+		cors.HeaderOrigin:        "https://admin.example.com",
+		cors.HeaderRequestMethod: http.MethodGet,
+	})
+	require.Equal(t, "https://admin.example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "60", rec.Header().Get(cors.HeaderMaxAge))
+
+	// fields set by an entry override them
+	rec = serve(http.MethodOptions, "www.example.com", nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, 1, called)
+
+	tests := map[string]map[string]map[string]interface{}{
+		"nested hosts":   {"api.example.com": {"hosts": map[string]interface{}{}}},
+		"unknown field":  {"api.example.com": {"allowOrigin": "https://app.example.com"}},
+		"invalid value":  {"api.example.com": {"maxAge": "a minute"}},
+		"inner wildcard": {"api.*.example.com": {"allowOrigins": "https://app.example.com"}},
+	}
+
+	for name, hosts := range tests {
+		config.Hosts = hosts
+		_, err = traefik.New(context.Background(), noop, config, "cors")
+		require.NotNil(t, err, name)
+	}
+}
+
+func TestNew_HostsInherit(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.AllowAllOrigins = true
+	config.AllowCredentials = true
+	config.MaxAge = 120
+	config.Hosts = map[string]map[string]interface{}{
+		"api.example.com":   {"allowOrigins": "https://app.example.com", "maxAge": "60"},
+		"admin.example.com": {"allowCredentials": "false"},
+	}
+
+	h, err := traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	serve := func(host, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "https://"+host+"/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, origin)
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// the entry's origins replace the top-level wildcard, while credentials
+	// are inherited
+	rec := serve("api.example.com", "https://app.example.com")
+	require.Equal(t, "https://app.example.com", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "true", rec.Header().Get(cors.HeaderAllowCredentials))
+	require.Equal(t, "60", rec.Header().Get(cors.HeaderMaxAge))
+	require.Empty(t, serve("api.example.com", "https://evil.example.com").Header().Get(cors.HeaderAllowOrigin))
+
+	rec = serve("admin.example.com", "https://evil.example.com")
+	require.Equal(t, "*", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowCredentials))
+	require.Equal(t, "120", rec.Header().Get(cors.HeaderMaxAge))
+}
+
+func TestCorsPlugin_PreflightWithoutRequestHeaders(t *testing.T) {
+	called := 0
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called++ })
+
+	config := traefik.CreateConfig()
+	config.AllowMethods = []string{http.MethodGet, http.MethodDelete}
+
+	h, err := traefik.New(context.Background(), next, config, "cors")
+	require.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/items/1", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodDelete)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "GET, DELETE, HEAD, OPTIONS", rec.Header().Get(cors.HeaderAllowMethods))
+	require.Equal(t, 0, called)
+}
+
+func TestNew_PreflightStatus(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.PreflightStatus = http.StatusOK
+
+	h, err := traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	config.PreflightStatus = http.StatusInternalServerError
+
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+}
+
+func TestNew_MaxAge(t *testing.T) {
+	for maxAge, expected := range map[int]string{-1: "", 0: "0", 600: "600"} {
+		config := traefik.CreateConfig()
+		config.MaxAge = maxAge
+
+		h, err := traefik.New(context.Background(), noop, config, "cors")
+		require.Nil(t, err)
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+		req.Header.Set(cors.HeaderOrigin, "https://example.com")
+		req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		require.Equal(t, expected, rec.Header().Get(cors.HeaderMaxAge), maxAge)
+	}
+}
+
+func TestCorsPlugin_PlainOptions(t *testing.T) {
+	called := 0
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called++ })
+
+	config := traefik.CreateConfig()
+	config.AllowMethods = []string{http.MethodGet, http.MethodDelete}
+
+	serve := func() *httptest.ResponseRecorder {
+		h, err := traefik.New(context.Background(), next, config, "cors")
+		require.Nil(t, err)
+
+		req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/items/1", nil)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	serve()
+	require.Equal(t, 1, called)
+
+	config.OptionsPassthrough = false
+
+	rec := serve()
+	require.Equal(t, 1, called)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "GET, DELETE, HEAD, OPTIONS", rec.Header().Get(cors.HeaderAllow))
+	require.Empty(t, rec.Header().Get(cors.HeaderAllowMethods))
+}
+
+func TestNew_MaxAgeDuration(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.MaxAge = 30
+	config.MaxAgeDuration = "10m"
+
+	h, err := traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+	req.Header.Set(cors.HeaderRequestMethod, http.MethodGet)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, "600", rec.Header().Get(cors.HeaderMaxAge))
+
+	config.MaxAgeDuration = "ten minutes"
+
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+}
+
+func TestNew_AllowAll(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.AllowOrigins = []string{"https://example.com"}
+	config.AllowAllHeaders = true
+	config.AllowAllMethods = true
+
+	h, err := traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	res := preflight(t, h, "https://example.com")
+	require.Equal(t, "*", res.Header.Get(cors.HeaderAllowHeaders))
+	require.Equal(t, "*", res.Header.Get(cors.HeaderAllowMethods))
+	require.Empty(t, preflight(t, h, "https://example.org").Header.Get(cors.HeaderAllowOrigin))
+
+	// Setting a list too is rejected, unless it is the wildcard.
+	config.AllowAllOrigins = true
+
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+
+	config.AllowOrigins = []string{"*"}
+	config.AllowHeaders = []string{"X-Tenant"}
+
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+
+	config.AllowHeaders = []string{"*"}
+	config.AllowMethods = []string{http.MethodPut}
+
+	_, err = traefik.New(context.Background(), noop, config, "cors")
+	require.NotNil(t, err)
+
+	config.AllowMethods = nil
+
+	h, err = traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	res = preflight(t, h, "https://example.org")
+	require.Equal(t, "*", res.Header.Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "*", res.Header.Get(cors.HeaderAllowMethods))
+}
+
+func TestCorsPlugin_LenientPreflight(t *testing.T) {
+	config := traefik.CreateConfig()
+	config.LenientPreflight = true
+
+	h, err := traefik.New(context.Background(), noop, config, "cors")
+	require.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodOptions, "https://cors.example.com/api/", nil)
+	req.Header.Set(cors.HeaderOrigin, "https://example.com")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "*", rec.Header().Get(cors.HeaderAllowOrigin))
+	require.Equal(t, "HEAD, GET, POST, OPTIONS", rec.Header().Get(cors.HeaderAllowMethods))
+}